@@ -0,0 +1,165 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ChannelAliasesFile alias table file name in the logs root
+const ChannelAliasesFile = "aliases.json"
+
+// ChannelAlias logins a twitch user has logged under
+type ChannelAlias struct {
+	ID string `json:"id"`
+	// Login current login
+	Login string `json:"login"`
+	// Dir channel name used for the log directory, the first login we saw
+	Dir string `json:"dir"`
+	// Aliases previous logins
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// ChannelAliases maps channel logins to twitch user ids so renamed
+// channels keep writing to and serving from the same history
+type ChannelAliases struct {
+	sync.RWMutex
+	path    string
+	byID    map[string]*ChannelAlias
+	byLogin map[string]*ChannelAlias
+}
+
+// NewChannelAliases loads the alias table at path, a missing file is an empty table
+func NewChannelAliases(path string) (*ChannelAliases, error) {
+	a := &ChannelAliases{
+		path:    path,
+		byID:    map[string]*ChannelAlias{},
+		byLogin: map[string]*ChannelAlias{},
+	}
+	return a, a.Reload()
+}
+
+// Reload reread the alias table
+func (a *ChannelAliases) Reload() error {
+	var entries []*ChannelAlias
+	data, err := ioutil.ReadFile(a.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return err
+		}
+	}
+
+	byID := make(map[string]*ChannelAlias, len(entries))
+	byLogin := make(map[string]*ChannelAlias, len(entries))
+	for _, e := range entries {
+		byID[e.ID] = e
+		byLogin[strings.ToLower(e.Login)] = e
+	}
+	// current logins take precedence over ones freed by a rename
+	for _, e := range entries {
+		for _, alias := range e.Aliases {
+			if _, ok := byLogin[strings.ToLower(alias)]; !ok {
+				byLogin[strings.ToLower(alias)] = e
+			}
+		}
+	}
+	a.Lock()
+	a.byID = byID
+	a.byLogin = byLogin
+	a.Unlock()
+	return nil
+}
+
+// Save persist the alias table
+func (a *ChannelAliases) Save() error {
+	a.RLock()
+	entries := make([]*ChannelAlias, 0, len(a.byID))
+	for _, e := range a.byID {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Dir < entries[j].Dir })
+	data, err := json.Marshal(entries)
+	a.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "\t"); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.path), 0755); err != nil {
+		return err
+	}
+	_, err = WriteFileAtomic(a.path, buf.Bytes())
+	return err
+}
+
+// Update records the current login of a user id, it returns the previous
+// login if the user renamed
+func (a *ChannelAliases) Update(id, login string) (prev string, renamed bool) {
+	login = strings.ToLower(login)
+	a.Lock()
+	defer a.Unlock()
+	e, ok := a.byID[id]
+	if !ok {
+		e = &ChannelAlias{ID: id, Login: login, Dir: login}
+		// a login freed by a rename was picked up by another user, don't
+		// mix their logs into the old history
+		if old, ok := a.byLogin[login]; ok && strings.EqualFold(old.Dir, login) {
+			e.Dir = login + "-" + id
+		}
+		a.byID[id] = e
+		a.byLogin[login] = e
+		return "", false
+	}
+	if e.Login == login {
+		return "", false
+	}
+	prev = e.Login
+	if !inSlice(e.Aliases, prev) {
+		e.Aliases = append(e.Aliases, prev)
+	}
+	e.Login = login
+	a.byLogin[login] = e
+	return prev, true
+}
+
+// ID returns the user id of a current or previous login
+func (a *ChannelAliases) ID(login string) (string, bool) {
+	a.RLock()
+	defer a.RUnlock()
+	if e, ok := a.byLogin[strings.ToLower(login)]; ok {
+		return e.ID, true
+	}
+	return "", false
+}
+
+// Login returns the current login of a user id
+func (a *ChannelAliases) Login(id string) (string, bool) {
+	a.RLock()
+	defer a.RUnlock()
+	if e, ok := a.byID[id]; ok {
+		return e.Login, true
+	}
+	return "", false
+}
+
+// Resolve returns the directory channel name for any of a channel's
+// logins, unknown logins resolve to themselves
+func (a *ChannelAliases) Resolve(login string) string {
+	a.RLock()
+	defer a.RUnlock()
+	if e, ok := a.byLogin[strings.ToLower(login)]; ok {
+		return e.Dir
+	}
+	return login
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChannelAliasesRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orl-aliases-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ChannelAliasesFile)
+
+	a, err := NewChannelAliases(path)
+	if err != nil {
+		t.Fatalf("error loading missing aliases %s", err)
+	}
+	if _, renamed := a.Update("1", "OldName"); renamed {
+		t.Error("first login reported as rename")
+	}
+	prev, renamed := a.Update("1", "newname")
+	if !renamed || prev != "oldname" {
+		t.Errorf("expected rename from oldname, got: %q %v", prev, renamed)
+	}
+	// the freed login is picked up by someone else
+	a.Update("2", "oldname")
+	if err := a.Save(); err != nil {
+		t.Fatalf("error saving aliases %s", err)
+	}
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 1 {
+		t.Errorf("expected only the alias table, got: %d files %v", len(files), err)
+	}
+
+	b, err := NewChannelAliases(path)
+	if err != nil {
+		t.Fatalf("error loading aliases %s", err)
+	}
+	if dir := b.Resolve("NewName"); dir != "oldname" {
+		t.Errorf("invalid dir for renamed channel, got: %s; want: oldname", dir)
+	}
+	if dir := b.Resolve("oldname"); dir != "oldname-2" {
+		t.Errorf("invalid dir for reused login, got: %s; want: oldname-2", dir)
+	}
+	if dir := b.Resolve("unknown"); dir != "unknown" {
+		t.Errorf("invalid dir for unknown channel, got: %s; want: unknown", dir)
+	}
+}
@@ -0,0 +1,266 @@
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+)
+
+// Backpressure policies applied when a chat client's message buffer is full
+const (
+	BackpressureBlock = "block"
+	BackpressureSpill = "spill"
+	BackpressureDrop  = "drop"
+)
+
+// per channel message counters, served on /debug/vars
+var (
+	ReceivedMessages = expvar.NewMap("received_messages")
+	SpilledMessages  = expvar.NewMap("spilled_messages")
+	DroppedMessages  = expvar.NewMap("dropped_messages")
+)
+
+// MessageSink delivers messages from a chat client to its consumers
+type MessageSink struct {
+	policy   string
+	messages chan *Message
+	quit     <-chan struct{}
+	spill    *spillQueue
+	next     *Message // popped by drain but not delivered before quit
+	wg       sync.WaitGroup
+}
+
+// NewMessageSink creates a sink using the configured backpressure policy
+func NewMessageSink(messages chan *Message, quit <-chan struct{}) *MessageSink {
+	s := &MessageSink{
+		policy:   BackpressureDrop,
+		messages: messages,
+		quit:     quit,
+	}
+	if conf := GetConfig(); conf != nil && conf.Backpressure != "" {
+		s.policy = conf.Backpressure
+	}
+
+	if s.policy == BackpressureSpill {
+		var dir string
+		if conf := GetConfig(); conf != nil {
+			dir = conf.SpillPath
+		}
+		q, err := newSpillQueue(dir)
+		if err != nil {
+			log.Printf("error creating spill queue, dropping messages instead %s", err)
+			s.policy = BackpressureDrop
+			return s
+		}
+		s.spill = q
+		s.wg.Add(1)
+		go s.drain()
+	}
+	return s
+}
+
+// Push delivers m or handles it according to the backpressure policy
+func (s *MessageSink) Push(m *Message) {
+	ReceivedMessages.Add(m.Channel, 1)
+
+	switch s.policy {
+	case BackpressureBlock:
+		select {
+		case s.messages <- m:
+		case <-s.quit:
+			DroppedMessages.Add(m.Channel, 1)
+		}
+		return
+	case BackpressureSpill:
+		// keep ordering by queueing behind spilled messages
+		if s.spill.Len() == 0 {
+			select {
+			case s.messages <- m:
+				return
+			default:
+			}
+		}
+		if err := s.spill.Push(m); err != nil {
+			log.Printf("error spilling message %s", err)
+			DroppedMessages.Add(m.Channel, 1)
+			return
+		}
+		SpilledMessages.Add(m.Channel, 1)
+		return
+	}
+
+	select {
+	case s.messages <- m:
+	default:
+		log.Println("error messages channel full :(")
+		DroppedMessages.Add(m.Channel, 1)
+	}
+}
+
+func (s *MessageSink) drain() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.spill.notify:
+		case <-s.quit:
+			return
+		}
+		for {
+			m, err := s.spill.Pop()
+			if err != nil {
+				log.Printf("error reading spilled message %s", err)
+				break
+			}
+			if m == nil {
+				break
+			}
+			select {
+			case s.messages <- m:
+				s.spill.Ack()
+			case <-s.quit:
+				s.next = m
+				return
+			}
+		}
+	}
+}
+
+// Close delivers the messages still spilled and closes the message channel,
+// consumers have to read the channel until it's closed
+func (s *MessageSink) Close() {
+	s.wg.Wait()
+	if s.spill != nil {
+		if s.next != nil {
+			s.messages <- s.next
+			s.spill.Ack()
+		}
+		for {
+			m, err := s.spill.Pop()
+			if err != nil {
+				log.Printf("error reading spilled message, discarding %d spilled messages %s", s.spill.Len(), err)
+				break
+			}
+			if m == nil {
+				break
+			}
+			s.messages <- m
+			s.spill.Ack()
+		}
+		s.spill.Close()
+	}
+	close(s.messages)
+}
+
+// spillQueue append only on-disk message queue
+type spillQueue struct {
+	sync.Mutex
+	w       *os.File
+	r       *os.File
+	br      *bufio.Reader
+	pending int
+	unread  int
+	notify  chan struct{}
+}
+
+func newSpillQueue(dir string) (*spillQueue, error) {
+	w, err := ioutil.TempFile(dir, "orl-spill-")
+	if err != nil {
+		return nil, err
+	}
+	r, err := os.Open(w.Name())
+	if err != nil {
+		w.Close()
+		os.Remove(w.Name())
+		return nil, err
+	}
+	return &spillQueue{
+		w:      w,
+		r:      r,
+		br:     bufio.NewReader(r),
+		notify: make(chan struct{}, 1),
+	}, nil
+}
+
+// Len number of queued messages that haven't been delivered
+func (q *spillQueue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	return q.pending
+}
+
+// Push append message to the queue
+func (q *spillQueue) Push(m *Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	q.Lock()
+	defer q.Unlock()
+	if _, err := q.w.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	q.pending++
+	q.unread++
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Pop returns the oldest unread message or nil if there is none
+func (q *spillQueue) Pop() (*Message, error) {
+	q.Lock()
+	defer q.Unlock()
+	if q.unread == 0 {
+		if q.pending == 0 {
+			return nil, q.reset()
+		}
+		return nil, nil
+	}
+	line, err := q.br.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	q.unread--
+	m := &Message{}
+	if err := json.Unmarshal(line, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Ack marks the last popped message as delivered
+func (q *spillQueue) Ack() {
+	q.Lock()
+	q.pending--
+	q.Unlock()
+}
+
+// reset truncates the drained queue file
+func (q *spillQueue) reset() error {
+	if err := q.w.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := q.w.Seek(0, 0); err != nil {
+		return err
+	}
+	if _, err := q.r.Seek(0, 0); err != nil {
+		return err
+	}
+	q.br.Reset(q.r)
+	return nil
+}
+
+// Close removes the queue file
+func (q *spillQueue) Close() {
+	q.Lock()
+	defer q.Unlock()
+	q.r.Close()
+	q.w.Close()
+	os.Remove(q.w.Name())
+}
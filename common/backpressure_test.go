@@ -0,0 +1,81 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMessageSinkSpill(t *testing.T) {
+	prev := config
+	config = &Config{Backpressure: BackpressureSpill, SpillPath: t.TempDir()}
+	defer func() { config = prev }()
+
+	messages := make(chan *Message, 2)
+	quit := make(chan struct{})
+	s := NewMessageSink(messages, quit)
+
+	const count = 100
+	for i := 0; i < count; i++ {
+		s.Push(&Message{Channel: "spilltest", Data: fmt.Sprint(i)})
+	}
+	for i := 0; i < count; i++ {
+		m := <-messages
+		if m.Data != fmt.Sprint(i) {
+			t.Fatalf("invalid message order, got: %s; want: %d", m.Data, i)
+		}
+	}
+	if n := SpilledMessages.Get("spilltest"); n == nil || n.String() == "0" {
+		t.Error("expected spilled messages to be counted")
+	}
+	close(quit)
+	s.Close()
+}
+
+func TestMessageSinkDrop(t *testing.T) {
+	prev := config
+	config = &Config{Backpressure: BackpressureDrop}
+	defer func() { config = prev }()
+
+	messages := make(chan *Message, 1)
+	quit := make(chan struct{})
+	s := NewMessageSink(messages, quit)
+	for i := 0; i < 3; i++ {
+		s.Push(&Message{Channel: "droptest"})
+	}
+	if n := DroppedMessages.Get("droptest"); n == nil || n.String() != "2" {
+		t.Errorf("invalid drop count, got: %v; want: 2", n)
+	}
+	close(quit)
+	s.Close()
+}
+
+func TestMessageSinkSpillClose(t *testing.T) {
+	prev := config
+	config = &Config{Backpressure: BackpressureSpill, SpillPath: t.TempDir()}
+	defer func() { config = prev }()
+
+	messages := make(chan *Message, 2)
+	quit := make(chan struct{})
+	s := NewMessageSink(messages, quit)
+
+	const count = 100
+	for i := 0; i < count; i++ {
+		s.Push(&Message{Channel: "spillclosetest", Data: fmt.Sprint(i)})
+	}
+	close(quit)
+	go s.Close()
+
+	var i int
+	for m := range messages {
+		if m.Data != fmt.Sprint(i) {
+			t.Fatalf("invalid message order, got: %s; want: %d", m.Data, i)
+		}
+		i++
+	}
+	if i != count {
+		t.Errorf("expected every spilled message after closing, got: %d; want: %d", i, count)
+	}
+	if n := DroppedMessages.Get("spillclosetest"); n != nil {
+		t.Errorf("expected no dropped messages, got: %v", n)
+	}
+}
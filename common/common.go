@@ -8,13 +8,21 @@ import (
 
 // const ...
 const (
-	HandshakeTimeout     = 10 * time.Second
-	MaxChannelsPerChat   = 50
-	MessageBufferSize    = 1000
-	SocketReadTimeout    = 6 * time.Minute
-	SocketReconnectDelay = 20 * time.Second
-	SocketWriteDebounce  = 500 * time.Millisecond
-	SocketWriteTimeout   = 5 * time.Second
+	HandshakeTimeout    = 10 * time.Second
+	MaxChannelsPerChat  = 50
+	MessageBufferSize   = 1000
+	SocketReadTimeout   = 6 * time.Minute
+	SocketWriteDebounce = 500 * time.Millisecond
+	SocketWriteTimeout  = 5 * time.Second
+)
+
+// month logs of twitch moderation and channel events in each month
+// directory, they're kept out of the day logs so they don't end up in the
+// logs of users with the same nick
+const (
+	BansFileName   = "bans.log"
+	RaidsFileName  = "raids.log"
+	EventsFileName = "events.log"
 )
 
 var messageNickPathUnsafe = regexp.MustCompile("[^a-zA-Z0-9_-]")
@@ -26,6 +34,7 @@ type Message struct {
 	Nick    string
 	Data    string
 	Time    time.Time
+	Tags    map[string]string
 }
 
 func (m *Message) String() string {
@@ -1,35 +1,107 @@
 package common
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/DataDog/zstd"
 )
 
-// WriteCompressedFile write compressed file
+// WriteCompressedFile write compressed file, the file is replaced atomically
 func WriteCompressedFile(path string, data []byte) (*os.File, error) {
 	cData, err := zstd.Compress(nil, data)
 	if err != nil {
 		return nil, err
 	}
+	return WriteFileAtomic(gzPath(path), cData)
+}
 
-	f, err := os.OpenFile(gzPath(path), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+// WriteFileAtomic writes data to a synced temp file and renames it over path,
+// the returned file is closed
+func WriteFileAtomic(path string, data []byte) (*os.File, error) {
+	f, err := createAtomic(path)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		f.Abort()
+		return nil, err
+	}
+	if err := f.Commit(); err != nil {
+		return nil, err
+	}
+
+	d, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	d.Close()
+	return d, nil
+}
 
-	if _, err := f.Write(cData); err != nil {
+// atomicFile temp file that replaces path once it's committed
+type atomicFile struct {
+	*os.File
+	path string
+}
+
+func createAtomic(path string) (*atomicFile, error) {
+	dir, name := filepath.Split(path)
+	tmp, err := ioutil.TempFile(dir, name+".tmp")
+	if err != nil {
 		return nil, err
 	}
-	return f, nil
+	return &atomicFile{File: tmp, path: path}, nil
+}
+
+// Commit sync the temp file and rename it into place
+func (f *atomicFile) Commit() error {
+	err := f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return syncDir(filepath.Dir(f.path))
+}
+
+// Abort discard the temp file
+func (f *atomicFile) Abort() {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// syncDir persists renames and removals in dir
+func syncDir(dir string) error {
+	if dir == "" {
+		dir = "."
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
 
-// ReadCompressedFile read compressed file
+// ReadCompressedFile read compressed file, the format is detected from its
+// content so zstd, gzip, legacy lz4 and plain files are all read
 func ReadCompressedFile(path string) ([]byte, error) {
-	f, err := os.Open(gzPath(path))
+	f, err := Store().Open(gzPath(path))
 	if err != nil {
 		return nil, err
 	}
@@ -40,52 +112,181 @@ func ReadCompressedFile(path string) ([]byte, error) {
 		return nil, err
 	}
 
-	dData, err := zstd.Decompress(nil, data)
+	dData, err := decompress(data, logDictDir(path))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error decompressing %s %s", gzPath(path), err)
 	}
 	return dData, nil
 }
 
-// CompressFile compress an existing file
-func CompressFile(path string) (*os.File, error) {
-	s, err := os.Open(path)
+// OpenLog streams a compressed log or its uncompressed counterpart if the
+// log hasn't been compressed yet
+func OpenLog(path string) (io.ReadCloser, error) {
+	return openLog(Store(), path)
+}
+
+func openLog(s LogStore, path string) (io.ReadCloser, error) {
+	f, err := s.Open(gzPath(path))
+	if os.IsNotExist(err) {
+		return s.Open(strings.TrimSuffix(path, ".gz"))
+	}
 	if err != nil {
 		return nil, err
 	}
-	data, err := ioutil.ReadAll(s)
-	s.Close()
+	r, err := newDecompressReader(f, logDictDir(path))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error decompressing %s %s", gzPath(path), err)
+	}
+	return &compressedReader{ReadCloser: r, f: f}, nil
+}
+
+type compressedReader struct {
+	io.ReadCloser
+	f io.Closer
+}
+
+// Close release the decoder and file
+func (r *compressedReader) Close() error {
+	err := r.ReadCloser.Close()
+	if ferr := r.f.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// CreateCompressedFile streams compressed data to a temp file that replaces
+// the file at path once it's closed
+func CreateCompressedFile(path string) (io.WriteCloser, error) {
+	f, err := createAtomic(gzPath(path))
 	if err != nil {
 		return nil, err
 	}
-	d, err := WriteCompressedFile(path, data)
+	return &compressedWriter{Writer: zstd.NewWriter(f), f: f}, nil
+}
+
+type compressedWriter struct {
+	*zstd.Writer
+	f *atomicFile
+}
+
+// Close flush the encoder and commit the file
+func (w *compressedWriter) Close() error {
+	if err := w.Writer.Close(); err != nil {
+		w.f.Abort()
+		return err
+	}
+	return w.f.Commit()
+}
+
+// Abort discard the temp file
+func (w *compressedWriter) Abort() {
+	w.Writer.Close()
+	w.f.Abort()
+}
+
+// CompressFile compress an existing file, the source is only removed after
+// the compressed copy was verified. Day logs are written as seekable frames.
+func CompressFile(path string) (*os.File, error) {
+	s, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer s.Close()
+
+	if strings.HasSuffix(path, ".txt") {
+		if err := compressSeekable(s, path); err != nil {
+			return nil, err
+		}
+	} else if err := compressStream(s, path); err != nil {
+		return nil, err
+	}
+
+	if err := verifyCompressed(path); err != nil {
+		return nil, fmt.Errorf("error verifying %s %s", gzPath(path), err)
+	}
 	if err := os.Remove(path); err != nil {
 		return nil, err
 	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	d, err := os.Open(gzPath(path))
+	if err != nil {
+		return nil, err
+	}
+	d.Close()
 	return d, nil
 }
 
+func compressStream(src io.Reader, path string) error {
+	w, err := CreateCompressedFile(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		w.(*compressedWriter).Abort()
+		return err
+	}
+	return w.Close()
+}
+
+// verifyCompressed compares the compressed copy of path with path, both are
+// read from local disk whichever store logs are read from
+func verifyCompressed(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	c, err := openLog(FileStore{}, gzPath(path))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	a := make([]byte, 32*1024)
+	b := make([]byte, 32*1024)
+	for {
+		n, aerr := io.ReadFull(src, a)
+		m, berr := io.ReadFull(c, b)
+		if n != m || !bytes.Equal(a[:n], b[:m]) {
+			return errors.New("content doesn't match")
+		}
+		if aerr == io.EOF || aerr == io.ErrUnexpectedEOF {
+			if berr != io.EOF && berr != io.ErrUnexpectedEOF {
+				return errors.New("content doesn't match")
+			}
+			return nil
+		}
+		if aerr != nil {
+			return aerr
+		}
+		if berr != nil {
+			return berr
+		}
+	}
+}
+
 // UncompressFile uncompress an existing file
 func UncompressFile(path string) (*os.File, error) {
 	d, err := ReadCompressedFile(path)
 	if err != nil {
 		return nil, err
 	}
-	f, err := os.OpenFile(strings.Replace(path, ".gz", "", -1), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	f, err := WriteFileAtomic(strings.Replace(path, ".gz", "", -1), d)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	if _, err := f.Write(d); err != nil {
+	// archived logs are read from the archive and have no local copy
+	if err := os.Remove(gzPath(path)); err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
-	if err := os.Remove(gzPath(path)); err != nil {
+	// the index only matches the compressed copy
+	if err := os.Remove(LogIndexPath(path)); err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
-	return f, nil
+	return f, syncDir(filepath.Dir(path))
 }
 
 func gzPath(path string) string {
@@ -0,0 +1,82 @@
+package common
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "2017-01-10.txt")
+	data := []byte("[2017-01-10 08:57:47 UTC] foo: bar\n")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := CompressFile(path)
+	if err != nil {
+		t.Fatalf("error compressing %s", err)
+	}
+	if f.Name() != path+".gz" {
+		t.Errorf("invalid compressed path, got: %s; want: %s", f.Name(), path+".gz")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected source to be removed")
+	}
+
+	r, err := OpenLog(path)
+	if err != nil {
+		t.Fatalf("error opening log %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(got) != string(data) {
+		t.Errorf("invalid streamed data, got: %q %v; want: %q", got, err, data)
+	}
+
+	if _, err := UncompressFile(path + ".gz"); err != nil {
+		t.Fatalf("error uncompressing %s", err)
+	}
+	got, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("invalid data, got: %q; want: %q", got, data)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected temp files to be gone, got: %d files", len(files))
+	}
+}
+
+// unreadableStore store every read fails from
+type unreadableStore struct {
+	FileStore
+}
+
+func (unreadableStore) Open(path string) (LogFile, error) {
+	return nil, errors.New("archive unavailable")
+}
+
+func TestCompressFileVerifiesLocally(t *testing.T) {
+	SetStore(unreadableStore{})
+	defer SetStore(FileStore{})
+	path := filepath.Join(t.TempDir(), "2017-01-10.txt")
+	if err := ioutil.WriteFile(path, []byte("[2017-01-10 08:57:47 UTC] foo: bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompressFile(path); err != nil {
+		t.Fatalf("error compressing %s", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected plain log to be removed, got: %v", err)
+	}
+}
@@ -2,10 +2,38 @@ package common
 
 import (
 	"log"
+	"sync"
 
 	"github.com/BurntSushi/toml"
 )
 
+// SourceConfig chat source settings
+type SourceConfig struct {
+	Kind string `toml:"kind"`
+	// irc settings
+	Server        string   `toml:"server"`
+	TLS           bool     `toml:"tls"`
+	TLSSkipVerify bool     `toml:"tlsSkipVerify"`
+	Nick          string   `toml:"nick"`
+	Pass          string   `toml:"pass"`
+	SASLUser      string   `toml:"saslUser"`
+	SASLPass      string   `toml:"saslPass"`
+	Channels      []string `toml:"channels"`
+	// Network prefix of the channel directories, defaults to the server's
+	// domain without its tld
+	Network string `toml:"network"`
+}
+
+// S3Config s3 compatible object store closed months are archived to
+type S3Config struct {
+	Endpoint  string `toml:"endpoint"`
+	Region    string `toml:"region"`
+	Bucket    string `toml:"bucket"`
+	Prefix    string `toml:"prefix"`
+	AccessKey string `toml:"accessKey"`
+	SecretKey string `toml:"secretKey"`
+}
+
 // Config settings
 type Config struct {
 	DestinyGG struct {
@@ -19,33 +47,60 @@ type Config struct {
 		SocketURL      string   `toml:"socketURL"`
 		OriginURL      string   `toml:"originURL"`
 		ClientID       string   `toml:"clientID"`
+		ClientSecret   string   `toml:"clientSecret"`
+		HelixURL       string   `toml:"helixURL"`
+		TokenURL       string   `toml:"tokenURL"`
 		OAuth          string   `toml:"oAuth"`
 		Nick           string   `toml:"nick"`
 		Admins         []string `toml:"admins"`
 		CommandChannel string   `toml:"commandChannel"`
+		JoinLimit      int      `toml:"joinLimit"`
 	} `toml:"twitch"`
 	Bot struct {
 		Admins []string `toml:"admins"`
 	} `toml:"bot"`
-	LogHost     string `toml:"logHost"`
-	MaxOpenLogs int    `toml:"maxOpenLogs"`
+	Sources      []SourceConfig `toml:"sources"`
+	LogHost      string         `toml:"logHost"`
+	MaxOpenLogs  int            `toml:"maxOpenLogs"`
+	Backpressure string         `toml:"backpressure"`
+	SpillPath    string         `toml:"spillPath"`
+	MetricsAddr  string         `toml:"metricsAddr"`
+	AdminAddr    string         `toml:"adminAddr"`
+	AdminToken   string         `toml:"adminToken"`
+	// FsyncInterval duration string, e.g. "5s"
+	FsyncInterval string   `toml:"fsyncInterval"`
+	S3            S3Config `toml:"s3"`
 }
 
-var config *Config
+var (
+	configLock sync.RWMutex
+	config     *Config
+)
 
 // SetupConfig loads config data from json
 func SetupConfig(path string) *Config {
-	config = &Config{}
-
-	_, err := toml.DecodeFile(path, &config)
+	c, err := ReloadConfig(path)
 	if err != nil {
 		log.Fatalf("error parsing config, err : %v", err)
 	}
+	return c
+}
 
-	return config
+// ReloadConfig parses the config again, the current config is kept if it's invalid
+func ReloadConfig(path string) (*Config, error) {
+	c := &Config{}
+	if _, err := toml.DecodeFile(path, c); err != nil {
+		return nil, err
+	}
+	configLock.Lock()
+	config = c
+	configLock.Unlock()
+	return c, nil
 }
 
 // GetConfig returns config
 func GetConfig() *Config {
+	configLock.RLock()
+	defer configLock.RUnlock()
 	return config
 }
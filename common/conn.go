@@ -0,0 +1,197 @@
+package common
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reconnect backoff settings
+const (
+	ReconnectBackoffMin    = time.Second
+	ReconnectBackoffMax    = 5 * time.Minute
+	ReconnectBackoffFactor = 2
+	ReconnectBackoffJitter = 0.2
+)
+
+// ConnectionStates current state of every chat connection, served on /debug/vars
+var ConnectionStates = expvar.NewMap("connection_states")
+
+// ConnState chat connection state
+type ConnState int32
+
+// connection states
+const (
+	StateConnecting ConnState = iota
+	StateConnected
+	StateBackoff
+	StateStopped
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateBackoff:
+		return "backoff"
+	case StateStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// Backoff jittered exponential backoff
+type Backoff struct {
+	Min     time.Duration
+	Max     time.Duration
+	Factor  float64
+	Jitter  float64
+	attempt int
+}
+
+// NewBackoff backoff using the default reconnect settings
+func NewBackoff() *Backoff {
+	return &Backoff{
+		Min:    ReconnectBackoffMin,
+		Max:    ReconnectBackoffMax,
+		Factor: ReconnectBackoffFactor,
+		Jitter: ReconnectBackoffJitter,
+	}
+}
+
+// Next returns the delay before the next attempt
+func (b *Backoff) Next() time.Duration {
+	d := float64(b.Min)
+	for i := 0; i < b.attempt && d < float64(b.Max); i++ {
+		d *= b.Factor
+	}
+	if d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	b.attempt++
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(d)
+}
+
+// Reset start over from Min after a successful attempt
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+var connLoopID int64
+
+// connLoop runs connect/read cycles of a chat client with backoff until it's stopped
+type connLoop struct {
+	name     string
+	ctx      context.Context
+	cancel   context.CancelFunc
+	backoff  *Backoff
+	state    int32
+	stateVar *expvar.String
+	wg       sync.WaitGroup
+	// onGap is called after reconnecting with the time the connection was down
+	onGap func(Gap)
+}
+
+func newConnLoop(kind string) *connLoop {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &connLoop{
+		name:     fmt.Sprintf("%s-%d", kind, atomic.AddInt64(&connLoopID, 1)),
+		ctx:      ctx,
+		cancel:   cancel,
+		backoff:  NewBackoff(),
+		stateVar: new(expvar.String),
+	}
+	l.setState(StateConnecting)
+	ConnectionStates.Set(l.name, l.stateVar)
+	return l
+}
+
+// Name connection name used in logs and metrics
+func (l *connLoop) Name() string {
+	return l.name
+}
+
+// State current connection state
+func (l *connLoop) State() ConnState {
+	return ConnState(atomic.LoadInt32(&l.state))
+}
+
+func (l *connLoop) setState(s ConnState) {
+	if prev := ConnState(atomic.SwapInt32(&l.state, int32(s))); prev != s {
+		log.Printf("%s %s", l.name, s)
+	}
+	l.stateVar.Set(s.String())
+}
+
+// run blocks until the loop is stopped, read should return when the
+// connection fails and closeConn must unblock a pending read
+func (l *connLoop) run(connect func() error, read func() error, closeConn func()) {
+	var down time.Time
+	for {
+		l.setState(StateConnecting)
+		err := connect()
+		if err == nil {
+			l.setState(StateConnected)
+			l.backoff.Reset()
+			if !down.IsZero() && l.onGap != nil {
+				l.onGap(Gap{Start: down, End: time.Now().UTC(), Source: l.name})
+			}
+			down = time.Time{}
+			err = read()
+		}
+		if down.IsZero() {
+			down = time.Now().UTC()
+		}
+		closeConn()
+		if l.ctx.Err() != nil {
+			l.setState(StateStopped)
+			return
+		}
+		delay := l.backoff.Next()
+		log.Printf("%s reconnecting in %s %s", l.name, delay.Round(time.Millisecond), err)
+		l.setState(StateBackoff)
+
+		t := time.NewTimer(delay)
+		select {
+		case <-l.ctx.Done():
+			t.Stop()
+			l.setState(StateStopped)
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// watchdog calls check every interval until the loop is stopped
+func (l *connLoop) watchdog(interval time.Duration, check func()) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-l.ctx.Done():
+				return
+			case <-t.C:
+				check()
+			}
+		}
+	}()
+}
+
+// stop cancels the loop and waits for the watchdog to exit
+func (l *connLoop) stop() {
+	l.cancel()
+	l.wg.Wait()
+}
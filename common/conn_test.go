@@ -0,0 +1,107 @@
+package common
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := &Backoff{Min: time.Second, Max: 10 * time.Second, Factor: 2}
+	want := []time.Duration{1, 2, 4, 8, 10, 10}
+	for i, w := range want {
+		if got := b.Next(); got != w*time.Second {
+			t.Errorf("invalid delay for attempt %d, got: %s; want: %s", i, got, w*time.Second)
+		}
+	}
+	b.Reset()
+	if got := b.Next(); got != time.Second {
+		t.Errorf("invalid delay after reset, got: %s", got)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := &Backoff{Min: time.Second, Max: time.Second, Factor: 2, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		if d := b.Next(); d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("jittered delay out of range, got: %s", d)
+		}
+	}
+}
+
+func TestConnLoop(t *testing.T) {
+	l := newConnLoop("test")
+	l.backoff = &Backoff{Min: time.Millisecond, Max: time.Millisecond, Factor: 1}
+
+	attempts := make(chan ConnState, 10)
+	connect := func() error {
+		attempts <- l.State()
+		if len(attempts) < 3 {
+			return errors.New("refused")
+		}
+		return nil
+	}
+	read := func() error {
+		<-l.ctx.Done()
+		return l.ctx.Err()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		l.run(connect, read, func() {})
+		close(done)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for l.State() != StateConnected {
+		select {
+		case <-deadline:
+			t.Fatalf("never connected, state: %s", l.State())
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if len(attempts) != 3 {
+		t.Errorf("invalid connect attempts, got: %d; want: 3", len(attempts))
+	}
+	l.stop()
+	<-done
+	if l.State() != StateStopped {
+		t.Errorf("invalid state after stop, got: %s", l.State())
+	}
+}
+
+func TestConnLoopGap(t *testing.T) {
+	l := newConnLoop("test")
+	l.backoff = &Backoff{Min: time.Millisecond, Max: time.Millisecond, Factor: 1}
+	gaps := make(chan Gap, 1)
+	l.onGap = func(g Gap) { gaps <- g }
+
+	var reads int
+	read := func() error {
+		reads++
+		if reads == 1 {
+			return errors.New("connection reset")
+		}
+		<-l.ctx.Done()
+		return l.ctx.Err()
+	}
+	go l.run(func() error { return nil }, read, func() {})
+	defer l.stop()
+
+	select {
+	case g := <-gaps:
+		if g.End.Before(g.Start) || g.Source != l.name {
+			t.Errorf("invalid gap, got: %+v", g)
+		}
+		m := NewGapMessage("test", g)
+		rg, err := GapFromMessage(m)
+		if err != nil {
+			t.Fatalf("error reading gap message %s", err)
+		}
+		if !rg.Start.Equal(g.Start) || !rg.End.Equal(g.End) {
+			t.Errorf("invalid gap from message, got: %+v; want: %+v", rg, g)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for gap")
+	}
+}
@@ -3,6 +3,8 @@ package common
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
@@ -14,90 +16,89 @@ import (
 
 // Destiny destiny.gg chat client
 type Destiny struct {
+	*connLoop
 	connLock      sync.Mutex
 	conn          *websocket.Conn
+	sendLock      sync.Mutex
 	lastMessageMu sync.RWMutex
 	lastMessage   time.Time
 	messages      chan *Message
-	quit          chan struct{}
+	sink          *MessageSink
 }
 
 // NewDestiny new destiny.gg chat client
 func NewDestiny() *Destiny {
-	return &Destiny{
+	c := &Destiny{
+		connLoop: newConnLoop("destinygg"),
 		messages: make(chan *Message, MessageBufferSize),
-		quit:     make(chan struct{}, 2),
 	}
+	c.sink = NewMessageSink(c.messages, c.ctx.Done())
+	c.onGap = func(g Gap) {
+		pushGaps(c.sink, c.Channels(), g)
+	}
+	return c
 }
 
 // Connect open ws connection
-func (c *Destiny) connect() {
+func (c *Destiny) connect() error {
 	dialer := websocket.Dialer{HandshakeTimeout: HandshakeTimeout}
 	header := http.Header{
 		"Origin": []string{GetConfig().DestinyGG.OriginURL},
 		"Cookie": []string{GetConfig().DestinyGG.Cookie},
 	}
-	var err error
-	c.connLock.Lock()
-	c.conn, _, err = dialer.Dial(GetConfig().DestinyGG.SocketURL, header)
-	c.connLock.Unlock()
+	conn, _, err := dialer.DialContext(c.ctx, GetConfig().DestinyGG.SocketURL, header)
 	if err != nil {
-		log.Printf("error connecting to destiny ws %s", err)
-		c.reconnect()
-		return
+		return fmt.Errorf("error connecting to destiny ws %s", err)
 	}
-	log.Printf("connected to destiny ws")
+	c.connLock.Lock()
+	c.conn = conn
+	c.connLock.Unlock()
 
-	go func() {
-		timeout := time.NewTimer(2 * time.Minute)
-		for range timeout.C {
-			c.lastMessageMu.RLock()
-			if time.Now().Sub(c.lastMessage).Minutes() > 2 {
-				log.Println("destiny timeout triggered")
-				c.lastMessageMu.RUnlock()
-				c.reconnect()
-				continue
-			}
-			c.lastMessageMu.RUnlock()
-		}
-	}()
+	c.lastMessageMu.Lock()
+	c.lastMessage = time.Now()
+	c.lastMessageMu.Unlock()
+	return nil
 }
 
+// reconnect drops the current connection, the run loop reconnects after backing off
 func (c *Destiny) reconnect() {
 	c.connLock.Lock()
 	if c.conn != nil {
 		c.conn.Close()
 	}
 	c.connLock.Unlock()
-	time.Sleep(SocketReconnectDelay)
-	c.connect()
 }
 
 // Run connect and start message read loop
 func (c *Destiny) Run() {
-	c.connect()
-	defer close(c.messages)
-	for {
-		select {
-		case <-c.quit:
-			return
-		default:
+	c.watchdog(time.Minute, func() {
+		c.lastMessageMu.RLock()
+		idle := time.Since(c.lastMessage)
+		c.lastMessageMu.RUnlock()
+		if c.State() == StateConnected && idle > 2*time.Minute {
+			log.Println("destiny timeout triggered")
+			c.reconnect()
 		}
+	})
+	go func() {
+		defer c.sink.Close()
+		c.run(c.connect, c.read, c.reconnect)
+	}()
+}
 
-		err := c.conn.SetReadDeadline(time.Now().Add(SocketReadTimeout))
-		if err != nil {
-			log.Println("SetReadDeadline triggered, reconnecting")
-			c.reconnect()
-			continue
+func (c *Destiny) read() error {
+	c.connLock.Lock()
+	conn := c.conn
+	c.connLock.Unlock()
+
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(SocketReadTimeout)); err != nil {
+			return fmt.Errorf("error setting the ReadDeadline %s", err)
 		}
 
-		c.connLock.Lock()
-		_, msg, err := c.conn.ReadMessage()
-		c.connLock.Unlock()
+		_, msg, err := conn.ReadMessage()
 		if err != nil {
-			log.Printf("error reading from websocket %s", err)
-			c.reconnect()
-			continue
+			return fmt.Errorf("error reading from websocket %s", err)
 		}
 
 		index := bytes.IndexByte(msg, ' ')
@@ -107,9 +108,11 @@ func (c *Destiny) Run() {
 		}
 
 		if strings.Index(string(msg), "PING") == 0 {
-			err := c.conn.WriteMessage(websocket.TextMessage, bytes.Replace(msg, []byte("PING"), []byte("PONG"), -1))
+			c.sendLock.Lock()
+			err := conn.WriteMessage(websocket.TextMessage, bytes.Replace(msg, []byte("PING"), []byte("PONG"), -1))
+			c.sendLock.Unlock()
 			if err != nil {
-				c.reconnect()
+				return fmt.Errorf("error sending PONG %s", err)
 			}
 			continue
 		}
@@ -125,35 +128,43 @@ func (c *Destiny) Run() {
 			continue
 		}
 
-		select {
-		case c.messages <- &Message{
+		c.sink.Push(&Message{
 			Type:    string(msg[:index]),
 			Channel: "Destinygg",
 			Nick:    data.Nick,
 			Data:    strings.Replace(data.Data, "\n", " ", -1),
 			Time:    time.Unix(data.Timestamp/1000, 0).UTC(),
-		}:
-		default:
-		}
+		})
 		c.lastMessageMu.Lock()
 		c.lastMessage = time.Now()
 		c.lastMessageMu.Unlock()
 	}
 }
 
+// Reconnect drop the connection and reconnect
+func (c *Destiny) Reconnect() {
+	c.reconnect()
+}
+
 // Stop ...
 func (c *Destiny) Stop() {
-	c.quit <- struct{}{}
-	c.connLock.Lock()
-	if c.conn != nil {
-		c.conn.Close()
-	}
-	c.connLock.Unlock()
+	c.cancel()
+	c.reconnect()
+	c.stop()
 }
 
 // Messages channel accessor
 func (c *Destiny) Messages() <-chan *Message { return c.messages }
 
+// Channels destiny.gg only has a single channel
+func (c *Destiny) Channels() []string { return []string{"Destinygg"} }
+
+// Join implement ChatSource
+func (c *Destiny) Join(ch string) error { return ErrSingleChannel }
+
+// Leave implement ChatSource
+func (c *Destiny) Leave(ch string) error { return ErrSingleChannel }
+
 func (c *Destiny) send(command string, msg map[string]string) error {
 	data, err := json.Marshal(msg)
 	if err != nil {
@@ -163,7 +174,18 @@ func (c *Destiny) send(command string, msg map[string]string) error {
 	buf.WriteString(command)
 	buf.WriteString(" ")
 	buf.Write(data)
-	if err := c.conn.WriteMessage(websocket.TextMessage, buf.Bytes()); err != nil {
+
+	c.connLock.Lock()
+	conn := c.conn
+	c.connLock.Unlock()
+	if conn == nil || c.State() != StateConnected {
+		return errors.New("not connected")
+	}
+
+	c.sendLock.Lock()
+	err = conn.WriteMessage(websocket.TextMessage, buf.Bytes())
+	c.sendLock.Unlock()
+	if err != nil {
 		log.Printf("error sending message %s", err)
 		c.reconnect()
 		return err
@@ -0,0 +1,235 @@
+package common
+
+/*
+#include <stddef.h>
+
+// zdict is compiled into github.com/DataDog/zstd but it doesn't wrap it
+size_t ZDICT_trainFromBuffer(void* dictBuffer, size_t dictBufferCapacity, const void* samplesBuffer, const size_t* samplesSizes, unsigned nbSamples);
+unsigned ZDICT_isError(size_t code);
+const char* ZDICT_getErrorName(size_t code);
+*/
+import "C"
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/DataDog/zstd"
+)
+
+// dictionary settings
+const (
+	// DictsDir directory in the logs root holding the dictionaries of each channel
+	DictsDir = "dicts"
+	// DictSize default size of trained dictionaries
+	DictSize = 110 * 1024
+	// dictIDBase ids below this are reserved by the zstd format
+	dictIDBase = 32768
+)
+
+// errors
+var (
+	ErrDictNotFound = errors.New("dictionary not found")
+)
+
+// Dict trained zstd dictionary
+type Dict struct {
+	ID   uint32
+	Data []byte
+}
+
+// TrainDict trains a dictionary of at most size bytes from samples
+func TrainDict(samples [][]byte, size int) ([]byte, error) {
+	var buf []byte
+	sizes := make([]C.size_t, 0, len(samples))
+	for _, s := range samples {
+		if len(s) == 0 {
+			continue
+		}
+		buf = append(buf, s...)
+		sizes = append(sizes, C.size_t(len(s)))
+	}
+	if len(sizes) == 0 {
+		return nil, errors.New("no samples")
+	}
+	dict := make([]byte, size)
+	n := C.ZDICT_trainFromBuffer(
+		unsafe.Pointer(&dict[0]),
+		C.size_t(len(dict)),
+		unsafe.Pointer(&buf[0]),
+		&sizes[0],
+		C.unsigned(len(sizes)),
+	)
+	if C.ZDICT_isError(n) != 0 {
+		return nil, fmt.Errorf("error training dictionary %s", C.GoString(C.ZDICT_getErrorName(n)))
+	}
+	return dict[:n], nil
+}
+
+// ChannelDictDir dictionary directory of a channel directory in root
+func ChannelDictDir(root, channel string) string {
+	return filepath.Join(root, DictsDir, channel)
+}
+
+// logDictDir dictionary directory of a log in root/channel/month
+func logDictDir(path string) string {
+	month := filepath.Dir(path)
+	channel := filepath.Dir(month)
+	return ChannelDictDir(filepath.Dir(channel), filepath.Base(channel))
+}
+
+// WriteDict stores a new version of a channel's dictionary, the id recorded
+// in the dictionary is replaced with the next free one
+func WriteDict(dir string, data []byte) (*Dict, error) {
+	if len(data) < 8 || !bytes.HasPrefix(data, dictMagic) {
+		return nil, errors.New("invalid dictionary")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	ids, err := dictIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+	id := uint32(dictIDBase)
+	if len(ids) > 0 {
+		id = ids[len(ids)-1] + 1
+	}
+	d := &Dict{ID: id, Data: append([]byte(nil), data...)}
+	binary.LittleEndian.PutUint32(d.Data[4:], id)
+	if _, err := WriteFileAtomic(dictPath(dir, id), d.Data); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// LatestDict returns the newest dictionary in dir, nil if there's none
+func LatestDict(dir string) (*Dict, error) {
+	ids, err := dictIDs(dir)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	return ReadDict(dir, ids[len(ids)-1])
+}
+
+var (
+	dictLock  sync.Mutex
+	dictCache = map[string]*Dict{}
+)
+
+// ReadDict reads a dictionary by id, dictionaries never change once written
+// so they're cached
+func ReadDict(dir string, id uint32) (*Dict, error) {
+	path := dictPath(dir, id)
+	dictLock.Lock()
+	defer dictLock.Unlock()
+	if d, ok := dictCache[path]; ok {
+		return d, nil
+	}
+	f, err := Store().Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrDictNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	d := &Dict{ID: id, Data: data}
+	dictCache[path] = d
+	return d, nil
+}
+
+func dictPath(dir string, id uint32) string {
+	return filepath.Join(dir, strconv.FormatUint(uint64(id), 10)+".dict")
+}
+
+// dictIDs sorted ids of the dictionaries in dir
+func dictIDs(dir string) ([]uint32, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.dict"))
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint32
+	for _, name := range names {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), ".dict"), 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+var dictMagic = []byte{0x37, 0xa4, 0x30, 0xec}
+
+// frameDictID reads the dictionary id from a zstd frame header, 0 if the
+// frame was compressed without one
+func frameDictID(head []byte) uint32 {
+	if len(head) < 5 || !bytes.HasPrefix(head, zstdMagic) {
+		return 0
+	}
+	fhd := head[4]
+	pos := 5
+	if fhd&0x20 == 0 {
+		// window descriptor
+		pos++
+	}
+	size := [4]int{0, 1, 2, 4}[fhd&0x3]
+	if size == 0 || len(head) < pos+size {
+		return 0
+	}
+	var id uint32
+	for i := size - 1; i >= 0; i-- {
+		id = id<<8 | uint32(head[pos+i])
+	}
+	return id
+}
+
+// frameDict returns the dictionary a frame was compressed with from the
+// dictionaries in dir, nil if it doesn't use one
+func frameDict(head []byte, dir string) ([]byte, error) {
+	id := frameDictID(head)
+	if id == 0 {
+		return nil, nil
+	}
+	if dir == "" {
+		return nil, ErrDictNotFound
+	}
+	d, err := ReadDict(dir, id)
+	if err != nil {
+		return nil, fmt.Errorf("error reading dictionary %d %s", id, err)
+	}
+	return d.Data, nil
+}
+
+// compressDict compresses data into a single frame with dict
+func compressDict(data, dict []byte, level int) ([]byte, error) {
+	if dict == nil {
+		return zstd.CompressLevel(nil, data, level)
+	}
+	var buf bytes.Buffer
+	w := zstd.NewWriterLevelDict(&buf, level, dict)
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
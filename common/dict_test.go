@@ -0,0 +1,88 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testDayLog(day time.Time, lines int) string {
+	var b strings.Builder
+	for i := 0; i < lines; i++ {
+		ts := day.Add(time.Duration(i) * 7 * time.Second)
+		fmt.Fprintf(&b, "%snick%d: message %d PepeLaugh\n", ts.Format("[2006-01-02 15:04:05 MST] "), i%23, i*31)
+	}
+	return b.String()
+}
+
+func TestDictCompression(t *testing.T) {
+	root := t.TempDir()
+	day := time.Date(2017, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	var samples [][]byte
+	for i := 0; i < 200; i++ {
+		samples = append(samples, []byte(testDayLog(day.AddDate(0, 0, -i%20).Add(time.Duration(i)*time.Minute), 50)))
+	}
+	data, err := TrainDict(samples, 8*1024)
+	if err != nil {
+		t.Fatalf("error training %s", err)
+	}
+	dir := ChannelDictDir(root, "Test chatlog")
+	d, err := WriteDict(dir, data)
+	if err != nil {
+		t.Fatalf("error writing dictionary %s", err)
+	}
+	if d.ID != dictIDBase {
+		t.Errorf("invalid id, got: %d; want: %d", d.ID, dictIDBase)
+	}
+	if d2, err := WriteDict(dir, data); err != nil || d2.ID != dictIDBase+1 {
+		t.Fatalf("invalid second version, got: %v %v", d2, err)
+	}
+
+	path := filepath.Join(root, "Test chatlog", "January 2017", "2017-01-10.txt")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	log := testDayLog(day, 3000)
+	if err := ioutil.WriteFile(path, []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompressFile(path); err != nil {
+		t.Fatalf("error compressing %s", err)
+	}
+	c, err := ioutil.ReadFile(gzPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := frameDictID(c); id != dictIDBase+1 {
+		t.Errorf("invalid frame dictionary, got: %d; want: %d", id, dictIDBase+1)
+	}
+
+	got, err := ReadCompressedFile(path)
+	if err != nil || string(got) != log {
+		t.Errorf("invalid data, got: %d bytes %v", len(got), err)
+	}
+	idx, err := ReadLogIndex(path)
+	if err != nil {
+		t.Fatalf("error reading index %s", err)
+	}
+	r, err := OpenLogFrames(path, idx, 1, 2)
+	if err != nil {
+		t.Fatalf("error opening frames %s", err)
+	}
+	got, err = ioutil.ReadAll(r)
+	r.Close()
+	lines := strings.SplitAfter(log, "\n")
+	want := strings.Join(lines[idx.Frames[1].Line:idx.Frames[1].Line+idx.Frames[1].Lines], "")
+	if err != nil || string(got) != want {
+		t.Errorf("invalid frame, got: %d bytes %v; want: %d bytes", len(got), err, len(want))
+	}
+
+	if _, err := Decompress(c); err == nil {
+		t.Error("expected error decompressing without the dictionary")
+	}
+}
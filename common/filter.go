@@ -0,0 +1,369 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// filter limits
+const (
+	// MaxFilterTerms most terms a filter may have
+	MaxFilterTerms = 16
+	// MaxFilterRegexLength longest re: pattern
+	MaxFilterRegexLength = 256
+	// MaxFilterRegexInsts largest compiled re: pattern, a bound on the cost
+	// of matching each line
+	MaxFilterRegexInsts = 2000
+	// FilterCacheSize compiled filters kept around
+	FilterCacheSize = 256
+)
+
+// errors
+var (
+	ErrTooManyFilterTerms   = errors.New("filter has too many terms")
+	ErrFilterRegexTooLong   = fmt.Errorf("regex is longer than %d characters", MaxFilterRegexLength)
+	ErrFilterRegexTooCostly = errors.New("regex is too complex")
+	ErrUnterminatedRegex    = errors.New("regex is missing its closing /")
+)
+
+var filterCache, _ = lru.New(FilterCacheSize)
+
+// linkPattern links matched by has:link
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S`)
+
+// Filter compiled filter query for log lines. Terms are separated by spaces
+// and all have to match, a leading - negates one:
+//
+//	word, "some words"      message contains the text, case insensitive
+//	nick:name               line is from name, several nick: terms match any
+//	re:/pattern/ re:/p/i    message matches the regex
+//	before:T after:T        line is before or after T, one of 15:04,
+//	                        15:04:05, 2006-01-02 or 2006-01-02T15:04
+//	has:link                message has a link
+//	mentions:name           message mentions name
+type Filter struct {
+	nicks [][]byte
+	terms []filterTerm
+	lower bool
+	time  bool
+	start time.Time
+	end   time.Time
+}
+
+type filterTerm struct {
+	not   bool
+	match func(l *filterLine) bool
+}
+
+// filterLine line being matched, the parts terms need are parsed once
+type filterLine struct {
+	line  []byte
+	nick  []byte
+	text  []byte
+	lower []byte
+	t     time.Time
+	ok    bool
+}
+
+// filterKeys keys of the key:value terms
+var filterKeys = []string{"nick:", "mentions:", "has:", "before:", "after:", "re:/"}
+
+// HasFilterOperators checks if q uses any of the filter syntax, queries
+// without it are plain text searches
+func HasFilterOperators(q string) bool {
+	if strings.ContainsRune(q, '"') {
+		return true
+	}
+	for _, word := range strings.Fields(q) {
+		if len(word) > 1 && word[0] == '-' {
+			return true
+		}
+		for _, key := range filterKeys {
+			if strings.HasPrefix(word, key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CompileFilter parses a filter query, filters are cached by query
+func CompileFilter(q string) (*Filter, error) {
+	if f, ok := filterCache.Get(q); ok {
+		return f.(*Filter), nil
+	}
+	f, err := ParseFilter(q)
+	if err != nil {
+		return nil, err
+	}
+	filterCache.Add(q, f)
+	return f, nil
+}
+
+// ParseFilter parses a filter query
+func ParseFilter(q string) (*Filter, error) {
+	f := &Filter{}
+	var n int
+	for q = strings.TrimSpace(q); q != ""; q = strings.TrimSpace(q) {
+		if n++; n > MaxFilterTerms {
+			return nil, ErrTooManyFilterTerms
+		}
+		var not bool
+		if q[0] == '-' && len(q) > 1 {
+			not = true
+			q = q[1:]
+		}
+
+		if strings.HasPrefix(q, "re:/") {
+			expr, rest, err := scanFilterRegex(q[len("re:/"):])
+			if err != nil {
+				return nil, err
+			}
+			re, err := compileFilterRegex(expr)
+			if err != nil {
+				return nil, err
+			}
+			q = rest
+			f.terms = append(f.terms, filterTerm{not, func(l *filterLine) bool {
+				return re.Match(l.text)
+			}})
+			continue
+		}
+
+		var word string
+		if q[0] == '"' {
+			end := strings.IndexByte(q[1:], '"')
+			if end == -1 {
+				word, q = q[1:], ""
+			} else {
+				word, q = q[1:end+1], q[end+2:]
+			}
+			f.addText(not, word)
+			continue
+		}
+		end := strings.IndexAny(q, " \t")
+		if end == -1 {
+			end = len(q)
+		}
+		word, q = q[:end], q[end:]
+
+		key, value := word, ""
+		if i := strings.IndexByte(word, ':'); i != -1 {
+			key, value = word[:i], word[i+1:]
+		}
+		if err := f.addKey(not, key, value, word); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// scanFilterRegex splits the pattern of a re: term from the rest of the
+// query, \/ stands for a / in the pattern
+func scanFilterRegex(q string) (expr, rest string, err error) {
+	var b strings.Builder
+	for i := 0; i < len(q); i++ {
+		switch {
+		case q[i] == '\\' && i+1 < len(q) && q[i+1] == '/':
+			b.WriteByte('/')
+			i++
+		case q[i] == '/':
+			expr, rest = b.String(), q[i+1:]
+			if strings.HasPrefix(rest, "i") {
+				expr, rest = "(?i)"+expr, rest[1:]
+			}
+			if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+				return "", "", fmt.Errorf("unexpected %q after regex", rest[0])
+			}
+			return expr, rest, nil
+		default:
+			b.WriteByte(q[i])
+		}
+	}
+	return "", "", ErrUnterminatedRegex
+}
+
+// compileFilterRegex compiles a re: pattern, rejecting ones whose compiled
+// program is too large to run on every line of a month
+func compileFilterRegex(expr string) (*regexp.Regexp, error) {
+	if len(expr) > MaxFilterRegexLength {
+		return nil, ErrFilterRegexTooLong
+	}
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return nil, err
+	}
+	if len(prog.Inst) > MaxFilterRegexInsts {
+		return nil, ErrFilterRegexTooCostly
+	}
+	return regexp.Compile(expr)
+}
+
+func (f *Filter) addText(not bool, text string) {
+	if text == "" {
+		return
+	}
+	needle := bytes.ToLower([]byte(text))
+	f.lower = true
+	f.terms = append(f.terms, filterTerm{not, func(l *filterLine) bool {
+		return bytes.Contains(l.lower, needle)
+	}})
+}
+
+func (f *Filter) addKey(not bool, key, value, word string) error {
+	switch key {
+	case "nick":
+		nick := []byte(strings.TrimPrefix(value, "@"))
+		if not {
+			f.terms = append(f.terms, filterTerm{true, func(l *filterLine) bool {
+				return bytes.EqualFold(l.nick, nick)
+			}})
+		} else {
+			f.nicks = append(f.nicks, nick)
+		}
+	case "mentions":
+		nick := []byte(strings.ToLower(strings.TrimPrefix(value, "@")))
+		if len(nick) == 0 {
+			return errors.New("mentions: needs a nick")
+		}
+		f.lower = true
+		f.terms = append(f.terms, filterTerm{not, func(l *filterLine) bool {
+			return mentions(l.lower, nick)
+		}})
+	case "has":
+		if value != "link" {
+			return fmt.Errorf("unknown has:%s, expected has:link", value)
+		}
+		f.terms = append(f.terms, filterTerm{not, func(l *filterLine) bool {
+			return linkPattern.Match(l.text)
+		}})
+	case "before", "after":
+		return f.addTime(not, key == "before", value)
+	default:
+		// words with a colon that isn't a known key, like links, are text
+		f.addText(not, word)
+	}
+	return nil
+}
+
+// addTime adds a before: or after: term, dates and date times bound the
+// filter's Range too
+func (f *Filter) addTime(not, before bool, value string) error {
+	f.time = true
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			clock := t.Sub(t.Truncate(24 * time.Hour))
+			f.terms = append(f.terms, filterTerm{not, func(l *filterLine) bool {
+				c := l.t.Sub(l.t.Truncate(24 * time.Hour))
+				if before {
+					return l.ok && c < clock
+				}
+				return l.ok && c > clock
+			}})
+			return nil
+		}
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		// after a date is from the start of the next day on
+		from := layout == "2006-01-02" && !before
+		if from {
+			t = t.AddDate(0, 0, 1)
+		}
+		if !not {
+			if before && (f.end.IsZero() || t.Before(f.end)) {
+				f.end = t
+			} else if !before && (f.start.IsZero() || t.After(f.start)) {
+				f.start = t
+			}
+		}
+		f.terms = append(f.terms, filterTerm{not, func(l *filterLine) bool {
+			if before {
+				return l.ok && l.t.Before(t)
+			}
+			if from {
+				return l.ok && !l.t.Before(t)
+			}
+			return l.ok && l.t.After(t)
+		}})
+		return nil
+	}
+	return fmt.Errorf("invalid time %q, expected 15:04, 15:04:05, 2006-01-02 or 2006-01-02T15:04", value)
+}
+
+// mentions checks if text has nick as a whole word
+func mentions(text, nick []byte) bool {
+	for off := 0; ; {
+		i := bytes.Index(text[off:], nick)
+		if i == -1 {
+			return false
+		}
+		start, end := off+i, off+i+len(nick)
+		if (start == 0 || !isNickByte(text[start-1])) && (end == len(text) || !isNickByte(text[end])) {
+			return true
+		}
+		off = start + 1
+	}
+}
+
+func isNickByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_'
+}
+
+// Range time range date bounds of the filter limit lines to, zero bounds are
+// open. Logs of days outside it don't need to be read.
+func (f *Filter) Range() (start, end time.Time) {
+	return f.start, f.end
+}
+
+// Match checks a log line against the filter. Text terms match the message
+// of chat lines and the whole line otherwise.
+func (f *Filter) Match(line []byte) bool {
+	l := filterLine{line: bytes.TrimSuffix(line, []byte("\n"))}
+	l.text = l.line
+	if len(l.line) > MessageTimeLayoutLength && l.line[0] == '[' {
+		rest := l.line[MessageTimeLayoutLength:]
+		if i := bytes.Index(rest, []byte(": ")); i > 0 {
+			l.nick, l.text = rest[:i], rest[i+2:]
+		}
+	}
+	if len(f.nicks) != 0 {
+		var found bool
+		for _, n := range f.nicks {
+			if bytes.EqualFold(l.nick, n) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.time && len(l.line) >= MessageTimeLayoutLength {
+		t, err := time.Parse(MessageTimeLayout, string(l.line[:MessageTimeLayoutLength]))
+		l.t, l.ok = t, err == nil
+	}
+	if f.lower {
+		l.lower = bytes.ToLower(l.text)
+	}
+	for _, t := range f.terms {
+		if t.match(&l) == t.not {
+			return false
+		}
+	}
+	return true
+}
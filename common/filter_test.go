@@ -0,0 +1,121 @@
+package common
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilterMatch(t *testing.T) {
+	lines := []string{
+		"[2017-01-10 08:00:00 UTC] Alice: check https://example.com PepeLaugh\n",
+		"[2017-01-10 12:30:00 UTC] bob: hey @alice, how's it going\n",
+		"[2017-01-11 09:15:00 UTC] carol: alicea is not alice_b\n",
+		"[2017-01-12 23:59:59 UTC] Ban: bob banned for 600 seconds\n",
+	}
+	cases := []struct {
+		q    string
+		want string
+	}{
+		{"pepelaugh", "0"},
+		{`"how's it"`, "1"},
+		{"nick:ALICE", "0"},
+		{"nick:alice nick:bob", "01"},
+		{"-nick:bob -nick:ban", "02"},
+		{"re:/^hey @\\w+/", "1"},
+		{"re:/ALICE_B/i", "2"},
+		{"re:/a\\/b|example\\.com/", "0"},
+		{"has:link", "0"},
+		{"-has:link", "123"},
+		{"mentions:alice", "1"},
+		{"mentions:@alice_b", "2"},
+		{"before:09:00", "0"},
+		{"after:09:00 before:23:00", "12"},
+		{"after:2017-01-10", "23"},
+		{"after:2017-01-11", "3"},
+		{"before:2017-01-11", "01"},
+		{"after:2017-01-10T12:00 before:2017-01-12", "12"},
+		{"banned 600", "3"},
+		{"https://example.com", "0"},
+	}
+	for _, c := range cases {
+		f, err := CompileFilter(c.q)
+		if err != nil {
+			t.Errorf("error compiling %q %s", c.q, err)
+			continue
+		}
+		var got string
+		for i, l := range lines {
+			if f.Match([]byte(l)) {
+				got += string('0' + rune(i))
+			}
+		}
+		if got != c.want {
+			t.Errorf("%q, got: %q; want: %q", c.q, got, c.want)
+		}
+	}
+}
+
+func TestFilterRange(t *testing.T) {
+	f, err := ParseFilter("after:2017-01-03 before:2017-01-20T10:00 before:2017-01-25 -before:2017-01-05")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, end := f.Range()
+	if !start.Equal(time.Date(2017, 1, 4, 0, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2017, 1, 20, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("invalid range, got: %s %s", start, end)
+	}
+}
+
+func TestHasFilterOperators(t *testing.T) {
+	cases := map[string]bool{
+		"pepe laugh":          false,
+		"https://example.com": false,
+		"a-b 5:30":            false,
+		"-":                   false,
+		"pepe -laugh":         true,
+		`"pepe laugh"`:        true,
+		"nick:bob":            true,
+		"re:/a/":              true,
+		"after:09:00":         true,
+		"has:link kappa":      true,
+	}
+	for q, want := range cases {
+		if got := HasFilterOperators(q); got != want {
+			t.Errorf("%q, got: %t; want: %t", q, got, want)
+		}
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	if _, err := ParseFilter(`re:/\w{900}\d{900}\s{900}/`); err != ErrFilterRegexTooCostly {
+		t.Errorf("expected costly regex error, got: %v", err)
+	}
+	for _, q := range []string{
+		"re:/unterminated",
+		"re:/a/x",
+		"re:/(/",
+		"re:/" + strings.Repeat("a", MaxFilterRegexLength+1) + "/",
+		"re:/(a{100}){100}/",
+		`re:/\w{900}\d{900}\s{900}/`,
+		"has:image",
+		"before:noon",
+		"mentions:",
+		strings.Repeat("a ", MaxFilterTerms+1),
+	} {
+		if _, err := ParseFilter(q); err == nil {
+			t.Errorf("expected error for %q", q)
+		}
+	}
+}
+
+func TestFilterCache(t *testing.T) {
+	a, err := CompileFilter("nick:alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := CompileFilter("nick:alice")
+	if a != b {
+		t.Error("expected cached filter")
+	}
+}
@@ -0,0 +1,151 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/DataDog/zstd"
+	lz4 "github.com/cloudflare/golz4"
+)
+
+// compressed file formats, the .gz suffix holds whichever one the file was
+// written with
+const (
+	FormatPlain = iota
+	FormatZstd
+	FormatGzip
+	FormatLZ4
+)
+
+// errors
+var (
+	ErrUnknownFormat = errors.New("unknown compression format")
+)
+
+var (
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// lz4MaxRatio upper bound of the lz4 compression ratio, used to reject
+// implausible size headers before allocating the output
+const lz4MaxRatio = 255
+
+// SniffFormat guesses the format of a file from its first bytes. Legacy lz4
+// files only have a 4 byte big endian size header, so text whose first bytes
+// are printable is taken for plain data.
+func SniffFormat(head []byte) int {
+	switch {
+	case bytes.HasPrefix(head, zstdMagic):
+		return FormatZstd
+	case bytes.HasPrefix(head, gzipMagic):
+		return FormatGzip
+	case len(head) < 4 || isText(head[:4]):
+		return FormatPlain
+	default:
+		return FormatLZ4
+	}
+}
+
+func isText(b []byte) bool {
+	for _, c := range b {
+		if c < 0x20 && c != '\n' && c != '\r' && c != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// Decompress decodes data in any of the supported formats
+func Decompress(data []byte) ([]byte, error) {
+	return decompress(data, "")
+}
+
+// decompress decodes data, zstd frames compressed with a dictionary look
+// it up in dictDir
+func decompress(data []byte, dictDir string) ([]byte, error) {
+	switch SniffFormat(data) {
+	case FormatZstd:
+		dict, err := frameDict(data, dictDir)
+		if err != nil {
+			return nil, err
+		}
+		if dict == nil {
+			return zstd.Decompress(nil, data)
+		}
+		r := zstd.NewReaderDict(bytes.NewReader(data), dict)
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case FormatGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case FormatLZ4:
+		return decompressLZ4(data)
+	default:
+		return data, nil
+	}
+}
+
+// decompressLZ4 decodes a size prefixed lz4 block
+func decompressLZ4(data []byte) ([]byte, error) {
+	if len(data) < 5 {
+		return nil, ErrUnknownFormat
+	}
+	size := binary.BigEndian.Uint32(data)
+	if uint64(size) > uint64(len(data)-4)*lz4MaxRatio {
+		return nil, ErrUnknownFormat
+	}
+	out := make([]byte, size)
+	if size == 0 {
+		return out, nil
+	}
+	if err := lz4.Uncompress(data[4:], out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// newDecompressReader streams r in whichever format it's in, lz4 blocks
+// can't be streamed so they're decoded whole
+func newDecompressReader(r io.Reader, dictDir string) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	// long enough for a zstd frame header up to the dictionary id
+	head, err := br.Peek(10)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch SniffFormat(head) {
+	case FormatZstd:
+		dict, err := frameDict(head, dictDir)
+		if err != nil {
+			return nil, err
+		}
+		if dict != nil {
+			return zstd.NewReaderDict(br, dict), nil
+		}
+		return zstd.NewReader(br), nil
+	case FormatGzip:
+		return gzip.NewReader(br)
+	case FormatLZ4:
+		data, err := ioutil.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+		data, err = decompressLZ4(data)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	default:
+		return ioutil.NopCloser(br), nil
+	}
+}
@@ -0,0 +1,72 @@
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataDog/zstd"
+	lz4 "github.com/cloudflare/golz4"
+)
+
+func TestReadCompressedFormats(t *testing.T) {
+	data := bytes.Repeat([]byte("[2017-01-10 08:57:47 UTC] nick: message\n"), 100)
+
+	z, err := zstd.Compress(nil, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(data)
+	gw.Close()
+	l := make([]byte, lz4.CompressBound(data))
+	n, err := lz4.Compress(data, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l4 := make([]byte, 4, n+4)
+	binary.BigEndian.PutUint32(l4, uint32(len(data)))
+	l4 = append(l4, l[:n]...)
+
+	cases := []struct {
+		name   string
+		data   []byte
+		format int
+	}{
+		{"zstd", z, FormatZstd},
+		{"gzip", gz.Bytes(), FormatGzip},
+		{"lz4", l4, FormatLZ4},
+		{"plain", data, FormatPlain},
+	}
+	dir := t.TempDir()
+	for _, c := range cases {
+		if f := SniffFormat(c.data); f != c.format {
+			t.Errorf("%s: invalid format, got: %d; want: %d", c.name, f, c.format)
+		}
+		path := filepath.Join(dir, c.name+".txt")
+		if err := ioutil.WriteFile(path+".gz", c.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		d, err := ReadCompressedFile(path)
+		if err != nil || !bytes.Equal(d, data) {
+			t.Errorf("%s: invalid data, got: %d bytes %v", c.name, len(d), err)
+		}
+		r, err := OpenLog(path)
+		if err != nil {
+			t.Fatalf("%s: error opening %s", c.name, err)
+		}
+		d, err = ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(d, data) {
+			t.Errorf("%s: invalid stream, got: %d bytes %v", c.name, len(d), err)
+		}
+	}
+
+	if _, err := Decompress([]byte{0, 0, 0, 9, 0xff, 0xff}); err == nil {
+		t.Error("expected error decoding corrupt lz4")
+	}
+}
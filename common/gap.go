@@ -0,0 +1,53 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// GapsFileName per month gap records in each month directory, appended to
+// whenever a gap touching the month ends
+const GapsFileName = "gaps.jsonl"
+
+// Gap period a chat connection was down
+type Gap struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Source string    `json:"source"`
+}
+
+// NewGapMessage wraps g in a GAP message for ch
+func NewGapMessage(ch string, g Gap) *Message {
+	return &Message{
+		Type:    "GAP",
+		Channel: ch,
+		Time:    g.End,
+		Tags: map[string]string{
+			"gap-start":  g.Start.Format(time.RFC3339Nano),
+			"gap-source": g.Source,
+		},
+	}
+}
+
+// GapFromMessage extracts the gap from a GAP message
+func GapFromMessage(m *Message) (Gap, error) {
+	if m.Type != "GAP" {
+		return Gap{}, fmt.Errorf("%s message is not a gap", m.Type)
+	}
+	start, err := time.Parse(time.RFC3339Nano, m.Tags["gap-start"])
+	if err != nil {
+		return Gap{}, fmt.Errorf("malformed gap start %v", err)
+	}
+	return Gap{
+		Start:  start,
+		End:    m.Time,
+		Source: m.Tags["gap-source"],
+	}, nil
+}
+
+// pushGaps records g for every channel the connection served
+func pushGaps(sink *MessageSink, channels []string, g Gap) {
+	for _, ch := range channels {
+		sink.Push(NewGapMessage(ch, g))
+	}
+}
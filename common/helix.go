@@ -0,0 +1,190 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// default twitch api endpoints
+const (
+	HelixBaseURL  = "https://api.twitch.tv/helix"
+	HelixTokenURL = "https://id.twitch.tv/oauth2/token"
+	// HelixMaxLogins max logins per users request
+	HelixMaxLogins = 100
+)
+
+// errors
+var (
+	ErrUserNotFound = errors.New("twitch user not found")
+)
+
+// HelixUser twitch user
+type HelixUser struct {
+	ID              string    `json:"id"`
+	Login           string    `json:"login"`
+	DisplayName     string    `json:"display_name"`
+	Type            string    `json:"type"`
+	BroadcasterType string    `json:"broadcaster_type"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Helix twitch helix api client using an app access token
+type Helix struct {
+	ClientID     string
+	ClientSecret string
+	BaseURL      string
+	TokenURL     string
+	client       *http.Client
+	tokenLock    sync.Mutex
+	token        string
+	expires      time.Time
+}
+
+// NewHelix new helix client for the default endpoints
+func NewHelix(clientID, clientSecret string) *Helix {
+	return &Helix{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		BaseURL:      HelixBaseURL,
+		TokenURL:     HelixTokenURL,
+		client:       &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// NewHelixFromConfig helix client using the twitch settings
+func NewHelixFromConfig() *Helix {
+	conf := GetConfig().Twitch
+	h := NewHelix(conf.ClientID, conf.ClientSecret)
+	if conf.HelixURL != "" {
+		h.BaseURL = conf.HelixURL
+	}
+	if conf.TokenURL != "" {
+		h.TokenURL = conf.TokenURL
+	}
+	return h
+}
+
+// AppToken returns a cached app access token, fetching a new one before it expires
+func (h *Helix) AppToken() (string, error) {
+	h.tokenLock.Lock()
+	defer h.tokenLock.Unlock()
+	if h.token != "" && time.Now().Add(time.Minute).Before(h.expires) {
+		return h.token, nil
+	}
+
+	form := url.Values{
+		"client_id":     {h.ClientID},
+		"client_secret": {h.ClientSecret},
+		"grant_type":    {"client_credentials"},
+	}
+	res, err := h.client.PostForm(h.TokenURL, form)
+	if err != nil {
+		return "", fmt.Errorf("error requesting app token %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error requesting app token %s", res.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("error decoding app token %s", err)
+	}
+	h.token = token.AccessToken
+	h.expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return h.token, nil
+}
+
+// invalidateToken drops the cached token after the api rejected it
+func (h *Helix) invalidateToken() {
+	h.tokenLock.Lock()
+	h.token = ""
+	h.tokenLock.Unlock()
+}
+
+// get sends an authenticated request and decodes the response into v,
+// a rejected token is refreshed once
+func (h *Helix) get(path string, query url.Values, v interface{}) error {
+	for retry := true; ; retry = false {
+		token, err := h.AppToken()
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest("GET", strings.TrimRight(h.BaseURL, "/")+path+"?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Client-Id", h.ClientID)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := h.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("error requesting %s %s", path, err)
+		}
+		if res.StatusCode == http.StatusUnauthorized && retry {
+			res.Body.Close()
+			h.invalidateToken()
+			continue
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("error requesting %s %s", path, res.Status)
+		}
+		return json.NewDecoder(res.Body).Decode(v)
+	}
+}
+
+// Users looks up users by login, unknown logins are left out
+func (h *Helix) Users(logins ...string) ([]HelixUser, error) {
+	lower := make([]string, len(logins))
+	for i, login := range logins {
+		lower[i] = strings.ToLower(login)
+	}
+	return h.users("login", lower)
+}
+
+// UsersByID looks up users by id, their current login survives renames
+func (h *Helix) UsersByID(ids ...string) ([]HelixUser, error) {
+	return h.users("id", ids)
+}
+
+func (h *Helix) users(key string, values []string) ([]HelixUser, error) {
+	var users []HelixUser
+	for len(values) > 0 {
+		n := len(values)
+		if n > HelixMaxLogins {
+			n = HelixMaxLogins
+		}
+		query := url.Values{key: values[:n]}
+		values = values[n:]
+
+		var res struct {
+			Data []HelixUser `json:"data"`
+		}
+		if err := h.get("/users", query, &res); err != nil {
+			return nil, err
+		}
+		users = append(users, res.Data...)
+	}
+	return users, nil
+}
+
+// User looks up a single user by login
+func (h *Helix) User(login string) (*HelixUser, error) {
+	users, err := h.Users(login)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrUserNotFound
+	}
+	return &users[0], nil
+}
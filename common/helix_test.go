@@ -0,0 +1,68 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// newHelixStub serves the token and users endpoints, the first token it
+// hands out is rejected by the users endpoint
+func newHelixStub(t *testing.T, tokens *int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("client_secret") != "secret" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		n := atomic.AddInt32(tokens, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": string(rune('a' + n)),
+			"expires_in":   3600,
+		})
+	})
+	mux.HandleFunc("/helix/users", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Client-Id") != "id" || r.Header.Get("Authorization") != "Bearer c" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var users []HelixUser
+		for _, login := range r.URL.Query()["login"] {
+			if login == "destiny" {
+				users = append(users, HelixUser{ID: "18074328", Login: login})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": users})
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestHelixUser(t *testing.T) {
+	var tokens int32
+	s := newHelixStub(t, &tokens)
+	defer s.Close()
+
+	h := NewHelix("id", "secret")
+	h.BaseURL = s.URL + "/helix"
+	h.TokenURL = s.URL + "/oauth2/token"
+
+	u, err := h.User("Destiny")
+	if err != nil {
+		t.Fatalf("error getting user %s", err)
+	}
+	if u.ID != "18074328" {
+		t.Errorf("invalid user id, got: %s; want: 18074328", u.ID)
+	}
+	if n := atomic.LoadInt32(&tokens); n != 2 {
+		t.Errorf("expected rejected token to be refreshed, got: %d tokens", n)
+	}
+
+	if _, err := h.User("nobody"); err != ErrUserNotFound {
+		t.Errorf("expected ErrUserNotFound, got: %v", err)
+	}
+	if n := atomic.LoadInt32(&tokens); n != 2 {
+		t.Errorf("expected cached token, got: %d tokens", n)
+	}
+}
@@ -0,0 +1,165 @@
+package common
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errors
+var (
+	ErrEmptyIRCMessage   = errors.New("empty irc message")
+	ErrMissingIRCCommand = errors.New("irc message is missing a command")
+)
+
+// IRCMessage IRCv3 message
+// > @badge-info=;badges=;color=#0D4200;display-name=ronni;tmi-sent-ts=1507246572675 :ronni!ronni@ronni.tmi.twitch.tv PRIVMSG #dallas :Kappa Keepo Kappa
+type IRCMessage struct {
+	Tags    map[string]string
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// ParseIRCMessage parse a single raw irc line
+func ParseIRCMessage(line string) (*IRCMessage, error) {
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, ErrEmptyIRCMessage
+	}
+
+	m := &IRCMessage{Tags: map[string]string{}}
+
+	if line[0] == '@' {
+		i := strings.IndexByte(line, ' ')
+		if i == -1 {
+			return nil, ErrMissingIRCCommand
+		}
+		parseIRCTags(m.Tags, line[1:i])
+		line = strings.TrimLeft(line[i+1:], " ")
+	}
+
+	if strings.HasPrefix(line, ":") {
+		i := strings.IndexByte(line, ' ')
+		if i == -1 {
+			return nil, ErrMissingIRCCommand
+		}
+		m.Prefix = line[1:i]
+		line = strings.TrimLeft(line[i+1:], " ")
+	}
+
+	for line != "" {
+		if line[0] == ':' {
+			m.Params = append(m.Params, line[1:])
+			break
+		}
+		i := strings.IndexByte(line, ' ')
+		if i == -1 {
+			m.Params = append(m.Params, line)
+			break
+		}
+		m.Params = append(m.Params, line[:i])
+		line = strings.TrimLeft(line[i+1:], " ")
+	}
+
+	if len(m.Params) == 0 {
+		return nil, ErrMissingIRCCommand
+	}
+	m.Command = strings.ToUpper(m.Params[0])
+	m.Params = m.Params[1:]
+	return m, nil
+}
+
+func parseIRCTags(tags map[string]string, raw string) {
+	for _, tag := range strings.Split(raw, ";") {
+		if tag == "" {
+			continue
+		}
+		i := strings.IndexByte(tag, '=')
+		if i == -1 {
+			tags[tag] = ""
+			continue
+		}
+		tags[tag[:i]] = unescapeIRCTag(tag[i+1:])
+	}
+}
+
+var ircTagEscapes = map[byte]byte{
+	':':  ';',
+	's':  ' ',
+	'\\': '\\',
+	'r':  '\r',
+	'n':  '\n',
+}
+
+func unescapeIRCTag(v string) string {
+	if strings.IndexByte(v, '\\') == -1 {
+		return v
+	}
+	var b strings.Builder
+	b.Grow(len(v))
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' {
+			b.WriteByte(v[i])
+			continue
+		}
+		i++
+		if i == len(v) {
+			break
+		}
+		if c, ok := ircTagEscapes[v[i]]; ok {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte(v[i])
+	}
+	return b.String()
+}
+
+// Nick returns the nick part of the prefix
+func (m *IRCMessage) Nick() string {
+	if i := strings.IndexAny(m.Prefix, "!@"); i != -1 {
+		return m.Prefix[:i]
+	}
+	return m.Prefix
+}
+
+// Param returns the i-th param or an empty string
+func (m *IRCMessage) Param(i int) string {
+	if i < 0 || i >= len(m.Params) {
+		return ""
+	}
+	return m.Params[i]
+}
+
+// Trailing returns the last param
+func (m *IRCMessage) Trailing() string {
+	return m.Param(len(m.Params) - 1)
+}
+
+// Channel returns the first param without the leading #
+func (m *IRCMessage) Channel() string {
+	return strings.TrimPrefix(m.Param(0), "#")
+}
+
+// Time returns the server timestamp from tmi-sent-ts or now if it's missing
+func (m *IRCMessage) Time() time.Time {
+	if ts, err := strconv.ParseInt(m.Tags["tmi-sent-ts"], 10, 64); err == nil {
+		return time.Unix(ts/1000, (ts%1000)*int64(time.Millisecond)).UTC()
+	}
+	return time.Now().UTC()
+}
+
+// SplitIRCLines splits a payload containing multiple irc lines
+func SplitIRCLines(data string) []string {
+	lines := strings.Split(data, "\n")
+	n := 0
+	for _, l := range lines {
+		if l = strings.TrimRight(l, "\r"); l != "" {
+			lines[n] = l
+			n++
+		}
+	}
+	return lines[:n]
+}
@@ -0,0 +1,138 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseIRCMessage(t *testing.T) {
+	line := "@badges=global_mod/1,turbo/1;color=#0D4200;display-name=ronni;emotes=;tmi-sent-ts=1507246572675;user-id=1337;user-type=global_mod :ronni!ronni@ronni.tmi.twitch.tv PRIVMSG #dallas :Kappa Keepo Kappa\r\n"
+	m, err := ParseIRCMessage(line)
+	if err != nil {
+		t.Fatalf("error parsing message %s", err)
+	}
+	if m.Command != "PRIVMSG" {
+		t.Errorf("invalid command, got: %s; want: PRIVMSG", m.Command)
+	}
+	if m.Nick() != "ronni" {
+		t.Errorf("invalid nick, got: %s; want: ronni", m.Nick())
+	}
+	if m.Channel() != "dallas" {
+		t.Errorf("invalid channel, got: %s; want: dallas", m.Channel())
+	}
+	if m.Trailing() != "Kappa Keepo Kappa" {
+		t.Errorf("invalid trailing param, got: %s", m.Trailing())
+	}
+	if m.Tags["user-id"] != "1337" || m.Tags["display-name"] != "ronni" {
+		t.Errorf("invalid tags, got: %v", m.Tags)
+	}
+	if want := time.Unix(1507246572, 675*int64(time.Millisecond)).UTC(); !m.Time().Equal(want) {
+		t.Errorf("invalid time, got: %s; want: %s", m.Time(), want)
+	}
+}
+
+func TestParseIRCMessageTagOrder(t *testing.T) {
+	a, err := ParseIRCMessage("@user-id=1;msg-id=resub;system-msg=ronni\\shas\\ssubscribed\\:\\s6\\smonths! :tmi.twitch.tv USERNOTICE #dallas")
+	if err != nil {
+		t.Fatalf("error parsing message %s", err)
+	}
+	b, err := ParseIRCMessage("@system-msg=ronni\\shas\\ssubscribed\\:\\s6\\smonths!;msg-id=resub;user-id=1 :tmi.twitch.tv USERNOTICE #dallas")
+	if err != nil {
+		t.Fatalf("error parsing message %s", err)
+	}
+	want := "ronni has subscribed; 6 months!"
+	for _, m := range []*IRCMessage{a, b} {
+		if m.Tags["system-msg"] != want {
+			t.Errorf("invalid system-msg, got: %s; want: %s", m.Tags["system-msg"], want)
+		}
+		if len(m.Params) != 1 {
+			t.Errorf("invalid params, got: %v", m.Params)
+		}
+	}
+}
+
+func TestParseIRCMessageInvalid(t *testing.T) {
+	for _, line := range []string{"", "\r\n", "@a=b", ":prefix"} {
+		if _, err := ParseIRCMessage(line); err == nil {
+			t.Errorf("expected error parsing %q", line)
+		}
+	}
+}
+
+func TestTwitchMessage(t *testing.T) {
+	tests := []struct {
+		line string
+		nick string
+		data string
+	}{
+		{
+			"@tmi-sent-ts=1507246572675;user-id=1337 :ronni!ronni@ronni.tmi.twitch.tv PRIVMSG #dallas :\x01ACTION waves\x01",
+			"ronni",
+			"/me waves",
+		},
+		{
+			"@msg-id=resub;system-msg=ronni\\shas\\ssubscribed\\sfor\\s6\\smonths!;tmi-sent-ts=1507246572675 :tmi.twitch.tv USERNOTICE #dallas :Great stream -- keep it up!",
+			"twitchnotify",
+			"ronni has subscribed for 6 months! [SubMessage]: Great stream -- keep it up!",
+		},
+	}
+	for _, test := range tests {
+		im, err := ParseIRCMessage(test.line)
+		if err != nil {
+			t.Fatalf("error parsing message %s", err)
+		}
+		m := twitchMessage(im)
+		if m == nil {
+			t.Fatalf("expected message for %q", test.line)
+		}
+		if m.Nick != test.nick || m.Data != test.data || m.Channel != "dallas" {
+			t.Errorf("invalid message, got: %s", m)
+		}
+		if m.Time.Unix() != 1507246572 {
+			t.Errorf("invalid time, got: %s", m.Time)
+		}
+	}
+}
+
+func TestTwitchModerationMessage(t *testing.T) {
+	tests := []struct {
+		line  string
+		typ   string
+		nick  string
+		data  string
+		extra string
+	}{
+		{"@ban-duration=600;target-user-id=1338;tmi-sent-ts=1507246572675 :tmi.twitch.tv CLEARCHAT #dallas :ronni", "TIMEOUT", "", "ronni", "600"},
+		{"@target-user-id=1338;tmi-sent-ts=1507246572675 :tmi.twitch.tv CLEARCHAT #dallas :ronni", "BAN", "", "ronni", ""},
+		{"@tmi-sent-ts=1507246572675 :tmi.twitch.tv CLEARCHAT #dallas", "CLEARCHAT", "", "", ""},
+		{"@login=ronni;target-msg-id=abc-123-def;tmi-sent-ts=1507246572675 :tmi.twitch.tv CLEARMSG #dallas :HeyGuys", "CLEARMSG", "ronni", "HeyGuys", ""},
+	}
+	for _, test := range tests {
+		im, err := ParseIRCMessage(test.line)
+		if err != nil {
+			t.Fatalf("error parsing message %s", err)
+		}
+		m := twitchMessage(im)
+		if m == nil {
+			t.Fatalf("expected message for %q", test.line)
+		}
+		if m.Type != test.typ || m.Nick != test.nick || m.Data != test.data || m.Tags["ban-duration"] != test.extra {
+			t.Errorf("invalid %s message, got: %s %s", test.typ, m.Type, m)
+		}
+	}
+}
+
+func TestTwitchUserNotice(t *testing.T) {
+	line := "@login=ronni;msg-id=raid;msg-param-viewerCount=42;system-msg=42\\sraiders\\sfrom\\sronni\\shave\\sjoined!;tmi-sent-ts=1507246572675 :tmi.twitch.tv USERNOTICE #dallas"
+	im, err := ParseIRCMessage(line)
+	if err != nil {
+		t.Fatalf("error parsing message %s", err)
+	}
+	m := twitchMessage(im)
+	if m == nil {
+		t.Fatal("expected raid message")
+	}
+	if m.Type != "USERNOTICE" || m.Tags["msg-id"] != "raid" || m.Nick != "ronni" || m.Data != "42 raiders from ronni have joined!" {
+		t.Errorf("invalid raid message, got: %s %s", m.Type, m)
+	}
+}
@@ -0,0 +1,308 @@
+package common
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IRC generic irc chat client
+type IRC struct {
+	*connLoop
+	conf     SourceConfig
+	connLock sync.Mutex
+	conn     net.Conn
+	sendLock sync.Mutex
+	ChLock   sync.RWMutex
+	channels []string
+	nick     string
+	messages chan *Message
+	sink     *MessageSink
+}
+
+// NewIRC new irc chat client
+func NewIRC(conf SourceConfig) *IRC {
+	c := &IRC{
+		connLoop: newConnLoop("irc"),
+		conf:     conf,
+		nick:     conf.Nick,
+		messages: make(chan *Message, MessageBufferSize),
+	}
+	for _, ch := range conf.Channels {
+		ch = ircChannelName(ch)
+		if !inSlice(c.channels, ch) {
+			c.channels = append(c.channels, ch)
+		}
+	}
+	c.sink = NewMessageSink(c.messages, c.ctx.Done())
+	c.onGap = func(g Gap) {
+		pushGaps(c.sink, c.Channels(), g)
+	}
+	return c
+}
+
+// Run connect and start message read loop
+func (c *IRC) Run() {
+	go func() {
+		defer c.sink.Close()
+		c.run(c.connect, c.read, c.close)
+	}()
+}
+
+func (c *IRC) connect() error {
+	dialer := &net.Dialer{Timeout: HandshakeTimeout}
+	var conn net.Conn
+	var err error
+	if c.conf.TLS {
+		host, _, _ := net.SplitHostPort(c.conf.Server)
+		tlsDialer := &tls.Dialer{
+			NetDialer: dialer,
+			Config: &tls.Config{
+				ServerName:         host,
+				InsecureSkipVerify: c.conf.TLSSkipVerify,
+			},
+		}
+		conn, err = tlsDialer.DialContext(c.ctx, "tcp", c.conf.Server)
+	} else {
+		conn, err = dialer.DialContext(c.ctx, "tcp", c.conf.Server)
+	}
+	if err != nil {
+		return fmt.Errorf("error connecting to irc %s %s", c.conf.Server, err)
+	}
+
+	c.connLock.Lock()
+	c.conn = conn
+	c.nick = c.conf.Nick
+	c.connLock.Unlock()
+
+	// message times come from server-time when the server supports it
+	if err := c.send("CAP REQ :server-time"); err != nil {
+		return err
+	}
+	if c.conf.SASLUser != "" {
+		if err := c.send("CAP REQ :sasl"); err != nil {
+			return err
+		}
+	}
+	if c.conf.Pass != "" {
+		if err := c.send("PASS " + c.conf.Pass); err != nil {
+			return err
+		}
+	}
+	if err := c.send("NICK " + c.nick); err != nil {
+		return err
+	}
+	return c.send(fmt.Sprintf("USER %s 0 * :%s", c.nick, c.nick))
+}
+
+func (c *IRC) close() {
+	c.connLock.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.connLock.Unlock()
+}
+
+func (c *IRC) read() error {
+	c.connLock.Lock()
+	conn := c.conn
+	c.connLock.Unlock()
+
+	r := bufio.NewReader(conn)
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(SocketReadTimeout)); err != nil {
+			return err
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		m, err := ParseIRCMessage(line)
+		if err != nil {
+			continue
+		}
+		if err := c.handle(m); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *IRC) handle(m *IRCMessage) error {
+	switch m.Command {
+	case "PING":
+		return c.send("PONG :" + m.Trailing())
+	case "ERROR":
+		return errors.New(m.Trailing())
+	case "CAP":
+		if m.Param(1) != "ACK" && m.Param(1) != "NAK" {
+			return nil
+		}
+		if strings.Contains(m.Trailing(), "sasl") {
+			if m.Param(1) == "ACK" {
+				return c.send("AUTHENTICATE PLAIN")
+			}
+			log.Printf("irc %s rejected sasl", c.conf.Server)
+			return c.send("CAP END")
+		}
+		// server-time, negotiation ends after authenticating when sasl is used
+		if c.conf.SASLUser == "" {
+			return c.send("CAP END")
+		}
+	case "AUTHENTICATE":
+		if m.Param(0) == "+" {
+			auth := c.conf.SASLUser + "\x00" + c.conf.SASLUser + "\x00" + c.conf.SASLPass
+			return c.send("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte(auth)))
+		}
+	case "903":
+		return c.send("CAP END")
+	case "902", "904", "905", "906":
+		log.Printf("irc %s sasl authentication failed %s", c.conf.Server, m.Trailing())
+		return c.send("CAP END")
+	case "433":
+		// nick collision
+		c.connLock.Lock()
+		c.nick += "_"
+		nick := c.nick
+		c.connLock.Unlock()
+		return c.send("NICK " + nick)
+	case "001":
+		c.ChLock.RLock()
+		channels := make([]string, len(c.channels))
+		copy(channels, c.channels)
+		c.ChLock.RUnlock()
+		for _, ch := range channels {
+			log.Printf("joining %s on %s", ch, c.conf.Server)
+			if err := c.send("JOIN #" + ch); err != nil {
+				return err
+			}
+		}
+	case "KICK":
+		c.connLock.Lock()
+		kicked := strings.EqualFold(m.Param(1), c.nick)
+		c.connLock.Unlock()
+		if kicked && c.inChannel(m.Channel()) {
+			return c.send("JOIN #" + m.Channel())
+		}
+	case "PRIVMSG":
+		if !strings.HasPrefix(m.Param(0), "#") {
+			return nil
+		}
+		data := strings.TrimSpace(m.Trailing())
+		data = strings.Replace(data, "\x01ACTION", "/me", -1)
+		data = strings.Replace(data, "\x01", "", -1)
+		c.sink.Push(&Message{
+			Type:    "MSG",
+			Channel: ircChannelName(m.Param(0)),
+			Nick:    m.Nick(),
+			Data:    data,
+			Time:    ircServerTime(m),
+			Tags:    m.Tags,
+		})
+	}
+	return nil
+}
+
+func (c *IRC) send(m string) error {
+	c.connLock.Lock()
+	conn := c.conn
+	c.connLock.Unlock()
+	if conn == nil {
+		return errors.New("not connected")
+	}
+
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+	if err := conn.SetWriteDeadline(time.Now().Add(SocketWriteTimeout)); err != nil {
+		return fmt.Errorf("error setting SetWriteDeadline %s", err)
+	}
+	if _, err := conn.Write([]byte(m + "\r\n")); err != nil {
+		return fmt.Errorf("error sending message %s", err)
+	}
+	return nil
+}
+
+func (c *IRC) inChannel(ch string) bool {
+	c.ChLock.RLock()
+	defer c.ChLock.RUnlock()
+	return inSlice(c.channels, ch)
+}
+
+// Join channel
+func (c *IRC) Join(ch string) error {
+	ch = ircChannelName(ch)
+	c.ChLock.Lock()
+	if inSlice(c.channels, ch) {
+		c.ChLock.Unlock()
+		return errors.New("already in channel")
+	}
+	c.channels = append(c.channels, ch)
+	c.ChLock.Unlock()
+	// channels are joined after registration if we aren't connected yet
+	if err := c.send("JOIN #" + ch); err != nil {
+		log.Printf("error joining %s %s", ch, err)
+	}
+	return nil
+}
+
+// Leave channel
+func (c *IRC) Leave(ch string) error {
+	ch = ircChannelName(ch)
+	c.ChLock.Lock()
+	for i, v := range c.channels {
+		if v == ch {
+			c.channels = append(c.channels[:i], c.channels[i+1:]...)
+			c.ChLock.Unlock()
+			if err := c.send("PART #" + ch); err != nil {
+				log.Printf("error leaving %s %s", ch, err)
+			}
+			return nil
+		}
+	}
+	c.ChLock.Unlock()
+	return errors.New("not in channel")
+}
+
+// Channels ...
+func (c *IRC) Channels() []string {
+	c.ChLock.RLock()
+	defer c.ChLock.RUnlock()
+	channels := make([]string, len(c.channels))
+	copy(channels, c.channels)
+	return channels
+}
+
+// Messages channel accessor
+func (c *IRC) Messages() <-chan *Message {
+	return c.messages
+}
+
+// Reconnect drop the connection and reconnect
+func (c *IRC) Reconnect() {
+	c.close()
+}
+
+// Stop disconnect and stop reconnecting
+func (c *IRC) Stop() {
+	c.cancel()
+	c.close()
+	c.stop()
+}
+
+func ircChannelName(ch string) string {
+	return strings.ToLower(strings.TrimPrefix(ch, "#"))
+}
+
+// ircServerTime returns the IRCv3 server-time or now if it's missing
+func ircServerTime(m *IRCMessage) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, m.Tags["time"]); err == nil {
+		return t.UTC()
+	}
+	return m.Time()
+}
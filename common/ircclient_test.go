@@ -0,0 +1,170 @@
+package common
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeIRCServer in-process irc server driven by the test
+type fakeIRCServer struct {
+	t     *testing.T
+	ln    net.Listener
+	conns chan *fakeIRCConn
+}
+
+type fakeIRCConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newFakeIRCServer(t *testing.T) *fakeIRCServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening %s", err)
+	}
+	s := &fakeIRCServer{t: t, ln: ln, conns: make(chan *fakeIRCConn, 4)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.conns <- &fakeIRCConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+		}
+	}()
+	return s
+}
+
+func (s *fakeIRCServer) accept() *fakeIRCConn {
+	select {
+	case c := <-s.conns:
+		return c
+	case <-time.After(5 * time.Second):
+		s.t.Fatal("timed out waiting for client connection")
+	}
+	return nil
+}
+
+func (c *fakeIRCConn) expect(want string) {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("error reading from client, want: %s; err: %s", want, err)
+	}
+	if got := strings.TrimRight(line, "\r\n"); got != want {
+		c.t.Fatalf("unexpected line from client, got: %s; want: %s", got, want)
+	}
+}
+
+func (c *fakeIRCConn) send(line string) {
+	if _, err := c.conn.Write([]byte(line + "\r\n")); err != nil {
+		c.t.Fatalf("error writing to client %s", err)
+	}
+}
+
+func nextMessage(t *testing.T, c ChatSource) *Message {
+	select {
+	case m := <-c.Messages():
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	return nil
+}
+
+func TestIRCClient(t *testing.T) {
+	s := newFakeIRCServer(t)
+	defer s.ln.Close()
+
+	c := NewIRC(SourceConfig{
+		Server:   s.ln.Addr().String(),
+		Nick:     "orl",
+		Channels: []string{"#Test"},
+	})
+	c.backoff.Min = 10 * time.Millisecond
+	c.Run()
+	defer c.Stop()
+
+	conn := s.accept()
+	conn.expect("CAP REQ :server-time")
+	conn.expect("NICK orl")
+	conn.expect("USER orl 0 * :orl")
+	conn.send(":irc.test CAP * ACK :server-time")
+	conn.expect("CAP END")
+	conn.send(":irc.test 433 * orl :Nickname is already in use")
+	conn.expect("NICK orl_")
+	conn.send(":irc.test 001 orl_ :Welcome")
+	conn.expect("JOIN #test")
+	conn.send("PING :irc.test")
+	conn.expect("PONG :irc.test")
+
+	conn.send("@time=2019-01-02T03:04:05.678Z :bob!bob@host PRIVMSG #test :hello there")
+	m := nextMessage(t, c)
+	if m.Channel != "test" || m.Nick != "bob" || m.Data != "hello there" {
+		t.Errorf("invalid message, got: %s", m)
+	}
+	if want := time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC); !m.Time.Equal(want) {
+		t.Errorf("invalid time, got: %s; want: %s", m.Time, want)
+	}
+
+	conn.send(":op!op@host KICK #test orl_ :bye")
+	conn.expect("JOIN #test")
+
+	// rejoin after the connection drops
+	conn.conn.Close()
+	conn = s.accept()
+	conn.expect("CAP REQ :server-time")
+	conn.expect("NICK orl")
+	conn.expect("USER orl 0 * :orl")
+	conn.send(":irc.test CAP * NAK :server-time")
+	conn.expect("CAP END")
+	conn.send(":irc.test 001 orl :Welcome")
+	conn.expect("JOIN #test")
+
+	if err := c.Join("other"); err != nil {
+		t.Fatalf("error joining channel %s", err)
+	}
+	conn.expect("JOIN #other")
+	if err := c.Leave("#test"); err != nil {
+		t.Fatalf("error leaving channel %s", err)
+	}
+	conn.expect("PART #test")
+	if got := c.Channels(); len(got) != 1 || got[0] != "other" {
+		t.Errorf("invalid channels, got: %v", got)
+	}
+}
+
+func TestIRCClientSASL(t *testing.T) {
+	s := newFakeIRCServer(t)
+	defer s.ln.Close()
+
+	c := NewIRC(SourceConfig{
+		Server:   s.ln.Addr().String(),
+		Nick:     "orl",
+		SASLUser: "orl",
+		SASLPass: "hunter2",
+		Channels: []string{"test"},
+	})
+	c.Run()
+	defer c.Stop()
+
+	conn := s.accept()
+	conn.expect("CAP REQ :server-time")
+	conn.expect("CAP REQ :sasl")
+	conn.expect("NICK orl")
+	conn.expect("USER orl 0 * :orl")
+	conn.send(":irc.test CAP * ACK :server-time")
+	conn.send(":irc.test CAP * ACK :sasl")
+	conn.expect("AUTHENTICATE PLAIN")
+	conn.send("AUTHENTICATE +")
+	conn.expect("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte("orl\x00orl\x00hunter2")))
+	conn.send(":irc.test 903 orl :SASL authentication successful")
+	conn.expect("CAP END")
+	conn.send(":irc.test 001 orl :Welcome")
+	conn.expect("JOIN #test")
+}
@@ -0,0 +1,220 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NickIndexFileName per month nick index in each month directory
+const NickIndexFileName = "nickindex.gz"
+
+const (
+	nickIndexVersion = 1
+	// maxNickIndexNick longest nick read from an index, longer ones mean the
+	// index is corrupt
+	maxNickIndexNick = 256
+)
+
+// NickStats activity of a nick in a month
+type NickStats struct {
+	// Nick case of the nick's latest line
+	Nick  string
+	Lines int
+	Bytes int
+	First time.Time
+	Last  time.Time
+	// Days bit d-1 is set if the nick wrote on day d
+	Days uint32
+}
+
+// Active checks if the nick wrote on day d of the month
+func (s *NickStats) Active(d int) bool {
+	return d >= 1 && d <= 31 && s.Days&(1<<uint(d-1)) != 0
+}
+
+// ActiveDays days of the month the nick wrote on
+func (s *NickStats) ActiveDays() []int {
+	var days []int
+	for d := 1; d <= 31; d++ {
+		if s.Active(d) {
+			days = append(days, d)
+		}
+	}
+	return days
+}
+
+// NickIndex nick activity of a month keyed by lower case nick
+type NickIndex map[string]*NickStats
+
+// NickIndexPath index path of a month directory
+func NickIndexPath(dir string) string {
+	return filepath.Join(dir, NickIndexFileName)
+}
+
+// Add counts a line of size bytes written by nick at t
+func (n NickIndex) Add(nick string, t time.Time, size int) {
+	key := strings.ToLower(nick)
+	s, ok := n[key]
+	if !ok {
+		s = &NickStats{First: t}
+		n[key] = s
+	}
+	s.Lines++
+	s.Bytes += size
+	if t.Before(s.First) {
+		s.First = t
+	}
+	if !t.Before(s.Last) {
+		s.Last = t
+		s.Nick = nick
+	}
+	s.Days |= 1 << uint(t.Day()-1)
+}
+
+// Get stats of a nick, case insensitive
+func (n NickIndex) Get(nick string) (*NickStats, bool) {
+	s, ok := n[strings.ToLower(nick)]
+	return s, ok
+}
+
+// WriteTo writes the compressed index to path
+func (n NickIndex) WriteTo(path string) error {
+	keys := make([]string, 0, len(n))
+	for k := range n {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) {
+		buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+	}
+	putUvarint(nickIndexVersion)
+	putUvarint(uint64(len(keys)))
+	for _, k := range keys {
+		s := n[k]
+		putUvarint(uint64(len(s.Nick)))
+		buf.WriteString(s.Nick)
+		putUvarint(uint64(s.Lines))
+		putUvarint(uint64(s.Bytes))
+		putUvarint(uint64(s.First.Unix()))
+		putUvarint(uint64(s.Last.Unix() - s.First.Unix()))
+		putUvarint(uint64(s.Days))
+	}
+	_, err := WriteCompressedFile(path, buf.Bytes())
+	return err
+}
+
+// BuildNickIndex indexes the day logs of a month directory
+func BuildNickIndex(dir string) (NickIndex, error) {
+	return BuildNickIndexBefore(dir, time.Time{})
+}
+
+// BuildNickIndexBefore indexes the lines of a month directory stamped before
+// t, a zero t indexes every line
+func BuildNickIndexBefore(dir string, t time.Time) (NickIndex, error) {
+	names, err := Store().ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	days := map[string]struct{}{}
+	for _, name := range names {
+		if strings.HasSuffix(name, ".txt") || strings.HasSuffix(name, ".txt.gz") {
+			days[strings.TrimSuffix(name, ".gz")] = struct{}{}
+		}
+	}
+	n := NickIndex{}
+	for day := range days {
+		if err := n.addLog(filepath.Join(dir, day), t); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// addLog counts the lines of a day log stamped before t
+func (n NickIndex) addLog(path string, before time.Time) error {
+	r, err := OpenLog(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		line := s.Bytes()
+		t, ok := logLineTime(line)
+		if !ok || !before.IsZero() && !t.Before(before) {
+			continue
+		}
+		rest := line[bytes.IndexByte(line, ']')+1:]
+		rest = bytes.TrimPrefix(rest, []byte(" "))
+		end := bytes.IndexByte(rest, ':')
+		if end <= 0 {
+			continue
+		}
+		n.Add(string(rest[:end]), t, len(line)+1)
+	}
+	return s.Err()
+}
+
+// ReadNickIndex reads a month's nick index
+func ReadNickIndex(path string) (NickIndex, error) {
+	data, err := ReadCompressedFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(bytes.NewReader(data))
+	readUvarint := func() uint64 {
+		if err != nil {
+			return 0
+		}
+		var v uint64
+		v, err = binary.ReadUvarint(r)
+		return v
+	}
+	if v := readUvarint(); err == nil && v != nickIndexVersion {
+		return nil, errors.New("unsupported nick index version")
+	}
+	count := readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(len(data)) {
+		return nil, errors.New("corrupt nick index " + path)
+	}
+	n := make(NickIndex, count)
+	for i := uint64(0); i < count; i++ {
+		size := readUvarint()
+		if size > maxNickIndexNick {
+			return nil, errors.New("corrupt nick index " + path)
+		}
+		nick := make([]byte, size)
+		if err == nil {
+			_, err = io.ReadFull(r, nick)
+		}
+		s := &NickStats{
+			Nick:  string(nick),
+			Lines: int(readUvarint()),
+			Bytes: int(readUvarint()),
+		}
+		first := int64(readUvarint())
+		last := first + int64(readUvarint())
+		s.First = time.Unix(first, 0).UTC()
+		s.Last = time.Unix(last, 0).UTC()
+		s.Days = uint32(readUvarint())
+		if err != nil {
+			return nil, errors.New("corrupt nick index " + path)
+		}
+		n[strings.ToLower(s.Nick)] = s
+	}
+	return n, nil
+}
@@ -0,0 +1,118 @@
+package common
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNickIndexAdd(t *testing.T) {
+	n := NickIndex{}
+	day := time.Date(2017, 1, 10, 8, 0, 0, 0, time.UTC)
+	n.Add("Nick", day, 10)
+	n.Add("nick", day.AddDate(0, 0, 5), 20)
+	n.Add("NICK", day.Add(-time.Hour), 5)
+
+	s, ok := n.Get("nIcK")
+	if !ok {
+		t.Fatal("nick not found")
+	}
+	if s.Lines != 3 || s.Bytes != 35 {
+		t.Errorf("invalid counts, got: %d lines %d bytes", s.Lines, s.Bytes)
+	}
+	if s.Nick != "nick" {
+		t.Errorf("expected case of latest line, got: %s", s.Nick)
+	}
+	if !s.First.Equal(day.Add(-time.Hour)) || !s.Last.Equal(day.AddDate(0, 0, 5)) {
+		t.Errorf("invalid first/last seen, got: %s %s", s.First, s.Last)
+	}
+	if days := s.ActiveDays(); len(days) != 2 || days[0] != 10 || days[1] != 15 {
+		t.Errorf("invalid active days, got: %v", days)
+	}
+}
+
+func TestNickIndexBuild(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "Test chatlog", "January 2017")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []int{3, 10} {
+		day := time.Date(2017, 1, d, 8, 0, 0, 0, time.UTC)
+		path := filepath.Join(dir, day.Format("2006-01-02")+".txt")
+		if err := ioutil.WriteFile(path, []byte(testDayLog(day, 230)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := CompressFile(filepath.Join(dir, "2017-01-03.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := BuildNickIndex(dir)
+	if err != nil {
+		t.Fatalf("error building index %s", err)
+	}
+	if len(n) != 23 {
+		t.Errorf("expected 23 nicks, got: %d", len(n))
+	}
+	s, ok := n.Get("nick0")
+	if !ok || s.Lines != 20 {
+		t.Fatalf("invalid stats for nick0, got: %+v", s)
+	}
+	if days := s.ActiveDays(); len(days) != 2 || days[0] != 3 || days[1] != 10 {
+		t.Errorf("invalid active days, got: %v", days)
+	}
+
+	if err := n.WriteTo(NickIndexPath(dir)); err != nil {
+		t.Fatalf("error writing index %s", err)
+	}
+	r, err := ReadNickIndex(NickIndexPath(dir))
+	if err != nil {
+		t.Fatalf("error reading index %s", err)
+	}
+	if len(r) != len(n) {
+		t.Errorf("expected %d nicks, got: %d", len(n), len(r))
+	}
+	for k, s := range n {
+		rs, ok := r[k]
+		if !ok || *rs != *s {
+			t.Errorf("invalid stats for %s, got: %+v; want: %+v", k, rs, s)
+		}
+	}
+
+	search, err := NewNickSearch(filepath.Join(root, "Test chatlog"), "NICK5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	search.date = time.Date(2017, 2, 1, 0, 0, 0, 0, time.UTC)
+	var found []string
+	for {
+		res, err := search.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		found = append(found, res.Day())
+	}
+	if len(found) != 2 || found[0] != "2017-01-10" || found[1] != "2017-01-03" {
+		t.Errorf("invalid search results, got: %v", found)
+	}
+	if nick, err := search.Month("January 2017"); err != nil || nick != "nick5" {
+		t.Errorf("invalid month search, got: %s %v", nick, err)
+	}
+}
+
+func TestNickIndexCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), NickIndexFileName)
+	if _, err := WriteCompressedFile(path, []byte{nickIndexVersion, 5, 200, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadNickIndex(path); err == nil {
+		t.Error("expected error reading corrupt index")
+	}
+}
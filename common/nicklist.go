@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"errors"
 	"io"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -54,11 +53,8 @@ func (n NickList) WriteTo(path string) error {
 		buf.WriteString(nick)
 		buf.WriteByte(0)
 	}
-	f, err := WriteCompressedFile(path+".writing", buf.Bytes())
-	if err != nil {
-		return err
-	}
-	return os.Rename(f.Name(), strings.Replace(f.Name(), ".writing", "", -1))
+	_, err := WriteCompressedFile(path, buf.Bytes())
+	return err
 }
 
 // NickListLower lower case nick list for case insensitive search
@@ -89,19 +85,16 @@ func (n NickCaseMap) Remove(nick string) {
 
 // NickSearch scans nick indexes in reverse chronological order
 type NickSearch struct {
-	nick   string
-	path   string
-	months map[string]struct{}
-	date   time.Time
+	nick    string
+	path    string
+	months  map[string]struct{}
+	date    time.Time
+	indexes map[string]NickIndex
 }
 
 // NewNickSearch create scanner
 func NewNickSearch(path string, nick string) (*NickSearch, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	names, err := f.Readdirnames(0)
+	names, err := Store().ReadDir(path)
 	if err != nil {
 		return nil, err
 	}
@@ -110,20 +103,45 @@ func NewNickSearch(path string, nick string) (*NickSearch, error) {
 		months[name] = struct{}{}
 	}
 	return &NickSearch{
-		nick:   strings.ToLower(nick),
-		path:   path,
-		months: months,
-		date:   time.Now().UTC().Add(24 * time.Hour),
+		nick:    strings.ToLower(nick),
+		path:    path,
+		months:  months,
+		date:    time.Now().UTC().Add(24 * time.Hour),
+		indexes: map[string]NickIndex{},
 	}, nil
 }
 
+// index nick index of month m, nil if the month has none
+func (n *NickSearch) index(m string) NickIndex {
+	idx, ok := n.indexes[m]
+	if !ok {
+		idx, _ = ReadNickIndex(NickIndexPath(filepath.Join(n.path, m)))
+		n.indexes[m] = idx
+	}
+	return idx
+}
+
 // Next find next occurrence
 func (n *NickSearch) Next() (*NickSearchResult, error) {
 	for {
 		n.date = n.date.Add(-24 * time.Hour)
-		if _, ok := n.months[n.date.Format("January 2006")]; !ok {
+		month := n.date.Format("January 2006")
+		if _, ok := n.months[month]; !ok {
 			return nil, io.EOF
 		}
+		// months with an index are answered from it, skipping days and
+		// months the nick didn't write in
+		if idx := n.index(month); idx != nil {
+			s, ok := idx.Get(n.nick)
+			if !ok {
+				n.date = time.Date(n.date.Year(), n.date.Month(), 1, 0, 0, 0, 0, time.UTC)
+				continue
+			}
+			if s.Active(n.date.Day()) {
+				return &NickSearchResult{s.Nick, n.date}, nil
+			}
+			continue
+		}
 		nicks := NickCaseMap{}
 		ReadNickList(nicks, n.path+n.date.Format("/January 2006/2006-01-02")+".nicks")
 		if nick, ok := nicks[n.nick]; ok {
@@ -137,11 +155,13 @@ func (n *NickSearch) Month(m string) (string, error) {
 	if _, ok := n.months[m]; !ok {
 		return "", errors.New("month not found")
 	}
-	f, err := os.Open(filepath.Join(n.path, m))
-	if err != nil {
-		return "", err
+	if idx := n.index(m); idx != nil {
+		if s, ok := idx.Get(n.nick); ok {
+			return s.Nick, nil
+		}
+		return "", errors.New("user not found in " + m)
 	}
-	nickfiles, err := f.Readdirnames(0)
+	nickfiles, err := Store().ReadDir(filepath.Join(n.path, m))
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,119 @@
+package common
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// twitch JOIN rate limit for regular accounts
+const (
+	TwitchJoinLimit  = 20
+	TwitchJoinPeriod = 10 * time.Second
+)
+
+// TokenBucket rate limiter allowing burst events at once and one more every interval
+type TokenBucket struct {
+	mu       sync.Mutex
+	burst    float64
+	interval time.Duration
+	tokens   float64
+	last     time.Time
+	seq      uint64
+}
+
+// NewTokenBucket new full bucket
+func NewTokenBucket(burst int, interval time.Duration) *TokenBucket {
+	return &TokenBucket{
+		burst:    float64(burst),
+		interval: interval,
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// Reserve takes a token and returns how long to wait before using it,
+// reservations are served in order
+func (b *TokenBucket) Reserve() time.Duration {
+	d, _ := b.reserve()
+	return d
+}
+
+func (b *TokenBucket) reserve() (time.Duration, uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += float64(now.Sub(b.last)) / float64(b.interval)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	b.seq++
+	if b.tokens >= 0 {
+		return 0, b.seq
+	}
+	return time.Duration(-b.tokens * float64(b.interval)), b.seq
+}
+
+// cancel gives back the token of a reservation that wasn't used. Later
+// reservations were queued behind it, their token is only given back if
+// there are none so they can't end up sharing a slot.
+func (b *TokenBucket) cancel(seq uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.seq == seq {
+		b.tokens++
+	}
+}
+
+// Wait blocks until a token is available or ctx is done
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	d, seq := b.reserve()
+	if d == 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		b.cancel(seq)
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+var (
+	twitchJoinOnce   sync.Once
+	twitchJoinBucket *TokenBucket
+)
+
+// TwitchJoinLimiter JOIN rate limiter shared by every twitch connection,
+// at most the configured joinLimit JOINs are sent in any 10 second window
+func TwitchJoinLimiter() *TokenBucket {
+	twitchJoinOnce.Do(func() {
+		limit := TwitchJoinLimit
+		if conf := GetConfig(); conf != nil && conf.Twitch.JoinLimit > 0 {
+			limit = conf.Twitch.JoinLimit
+		}
+		twitchJoinBucket = newJoinBucket(limit, TwitchJoinPeriod)
+	})
+	return twitchJoinBucket
+}
+
+// newJoinBucket bucket allowing limit events per period, half of them as
+// burst and the rest spread over the period. Burst and refill add up to at
+// most limit, a limit of one has no burst.
+func newJoinBucket(limit int, period time.Duration) *TokenBucket {
+	burst := limit / 2
+	if burst < 1 {
+		burst = 1
+	}
+	refill := limit - burst
+	if refill < 1 {
+		refill = 1
+		burst = limit - refill
+	}
+	return NewTokenBucket(burst, period/time.Duration(refill))
+}
@@ -0,0 +1,68 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(2, 100*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if d := b.Reserve(); d != 0 {
+			t.Errorf("expected burst token %d without waiting, got: %s", i, d)
+		}
+	}
+	d1 := b.Reserve()
+	d2 := b.Reserve()
+	if d1 <= 0 || d1 > 100*time.Millisecond {
+		t.Errorf("invalid wait for first token over burst, got: %s", d1)
+	}
+	if d2 <= d1 || d2 > 200*time.Millisecond {
+		t.Errorf("expected reservations to queue, got: %s after %s", d2, d1)
+	}
+}
+
+func TestJoinBucketLimit(t *testing.T) {
+	for limit := 1; limit <= 25; limit++ {
+		b := newJoinBucket(limit, 10*time.Second)
+		refill := int(10 * time.Second / b.interval)
+		if b.burst < 0 || refill < 1 || int(b.burst)+refill > limit {
+			t.Errorf("invalid bucket for limit %d, got: burst %v refill %d", limit, b.burst, refill)
+		}
+	}
+}
+
+func TestTokenBucketCancel(t *testing.T) {
+	b := NewTokenBucket(1, time.Hour)
+	b.Reserve()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.Wait(ctx); err != context.Canceled {
+		t.Fatalf("expected canceled wait, got: %v", err)
+	}
+	if d := b.Reserve(); d <= 0 || d > time.Hour {
+		t.Errorf("expected the canceled token back, got wait: %s", d)
+	}
+
+	// the token of a reservation others queued behind is kept
+	ctx, cancel = context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() { errc <- b.Wait(ctx) }()
+	for {
+		b.mu.Lock()
+		seq := b.seq
+		b.mu.Unlock()
+		if seq == 4 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	queued := b.Reserve()
+	cancel()
+	<-errc
+	if d := b.Reserve(); d <= queued {
+		t.Errorf("expected to queue behind %s, got: %s", queued, d)
+	}
+}
@@ -0,0 +1,397 @@
+package common
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Store logs archived in an s3 compatible bucket. Keys are the paths
+// relative to Root with Prefix in front, objects are addressed path style so
+// MinIO and other stand-ins work without dns.
+type S3Store struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	Root      string
+	client    *http.Client
+}
+
+// NewS3Store new store mirroring the local logs in root
+func NewS3Store(conf S3Config, root string) *S3Store {
+	region := conf.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3Store{
+		Endpoint:  strings.TrimSuffix(conf.Endpoint, "/"),
+		Region:    region,
+		Bucket:    conf.Bucket,
+		Prefix:    conf.Prefix,
+		AccessKey: conf.AccessKey,
+		SecretKey: conf.SecretKey,
+		Root:      root,
+		client:    &http.Client{Timeout: time.Minute},
+	}
+}
+
+// key object key of a local path
+func (s *S3Store) key(path string) (string, error) {
+	rel, err := filepath.Rel(s.Root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	if rel == "." {
+		return s.Prefix, nil
+	}
+	return s.Prefix + filepath.ToSlash(rel), nil
+}
+
+// ReadDir lists the objects and common prefixes under dir
+func (s *S3Store) ReadDir(dir string) ([]string, error) {
+	prefix, err := s.key(dir)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var names []string
+	var token string
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", prefix)
+		q.Set("delimiter", "/")
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		res, err := s.do("GET", "", q, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var list struct {
+			Contents []struct {
+				Key string
+			}
+			CommonPrefixes []struct {
+				Prefix string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(res.Body).Decode(&list)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range list.Contents {
+			names = append(names, strings.TrimPrefix(c.Key, prefix))
+		}
+		for _, p := range list.CommonPrefixes {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/"))
+		}
+		if !list.IsTruncated || list.NextContinuationToken == "" {
+			break
+		}
+		token = list.NextContinuationToken
+	}
+	if len(names) == 0 {
+		return nil, &os.PathError{Op: "open", Path: dir, Err: os.ErrNotExist}
+	}
+	return names, nil
+}
+
+// Open checks the object exists, its data is fetched once it's read
+func (s *S3Store) Open(path string) (LogFile, error) {
+	key, err := s.key(path)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.do("HEAD", key, nil, nil, nil)
+	if err != nil {
+		return nil, pathError(path, err)
+	}
+	res.Body.Close()
+	return &s3File{s: s, key: key, size: res.ContentLength}, nil
+}
+
+// Append objects can't be appended to
+func (s *S3Store) Append(path string) (io.WriteCloser, error) {
+	return nil, ErrAppendNotSupported
+}
+
+// Create buffers the object and uploads it when it's closed
+func (s *S3Store) Create(path string) (io.WriteCloser, error) {
+	key, err := s.key(path)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Writer{s: s, key: key}, nil
+}
+
+// Remove implement LogStore
+func (s *S3Store) Remove(path string) error {
+	key, err := s.key(path)
+	if err != nil {
+		return err
+	}
+	res, err := s.do("DELETE", key, nil, nil, nil)
+	if err != nil {
+		return pathError(path, err)
+	}
+	res.Body.Close()
+	return nil
+}
+
+var errS3NotFound = errors.New("not found")
+
+func pathError(path string, err error) error {
+	if err == errS3NotFound {
+		return &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return err
+}
+
+// do sends a signed request, responses other than 2xx are returned as errors
+func (s *S3Store) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/" + s.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = s3Escape(u.Path)
+	if query != nil {
+		u.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+	}
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, body, time.Now().UTC())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, errS3NotFound
+	}
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s %s %s", method, key, res.Status, msg)
+	}
+	return res, nil
+}
+
+// sign adds an aws signature v4 to req
+func (s *S3Store) sign(req *http.Request, body []byte, t time.Time) {
+	sum := sha256.Sum256(body)
+	payload := hex.EncodeToString(sum[:])
+	date := t.Format("20060102")
+	amzDate := t.Format("20060102T150405Z")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payload)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signed,
+		payload,
+	}, "\n")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signed, sig,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, s3Escape(k)+"="+strings.Replace(s3Escape(v), "/", "%2F", -1))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape uri encodes everything but unreserved characters and slashes
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// s3File object opened for reading, Read streams the whole object and
+// ReadAt fetches byte ranges
+type s3File struct {
+	s    *S3Store
+	key  string
+	size int64
+	body io.ReadCloser
+}
+
+// Size object size
+func (f *s3File) Size() int64 {
+	return f.size
+}
+
+// Read implement io.Reader
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.body == nil {
+		res, err := f.s.do("GET", f.key, nil, nil, nil)
+		if err != nil {
+			return 0, err
+		}
+		f.body = res.Body
+	}
+	return f.body.Read(p)
+}
+
+// ReadAt implement io.ReaderAt
+func (f *s3File) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > f.size {
+		end = f.size
+	}
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end-1))
+	res, err := f.s.do("GET", f.key, nil, header, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	n, err := io.ReadFull(res.Body, p[:end-off])
+	if err == nil && end-off < int64(len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// ReadRange streams n bytes from off, the body is closed with the file
+func (f *s3File) ReadRange(off, n int64) (io.Reader, error) {
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	res, err := f.s.do("GET", f.key, nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	if f.body != nil {
+		f.body.Close()
+	}
+	f.body = res.Body
+	return res.Body, nil
+}
+
+// Close implement io.Closer
+func (f *s3File) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+type s3Writer struct {
+	s       *S3Store
+	key     string
+	buf     bytes.Buffer
+	aborted bool
+}
+
+// Write implement io.Writer
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// Close uploads the object
+func (w *s3Writer) Close() error {
+	if w.aborted {
+		return nil
+	}
+	res, err := w.s.do("PUT", w.key, nil, nil, w.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Abort discards the object
+func (w *s3Writer) Abort() {
+	w.aborted = true
+}
@@ -0,0 +1,767 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/DataDog/zstd"
+)
+
+// search index settings
+const (
+	// SearchIndexFileName per month search index in each month directory
+	SearchIndexFileName = "search.idx"
+	// SearchSegmentLines lines per segment when whole logs are indexed
+	SearchSegmentLines = 10000
+	// MaxSearchTerm longest indexed word in bytes, longer ones are mostly
+	// links and spam
+	MaxSearchTerm = 32
+
+	searchSegmentHeader = 16
+)
+
+// errors
+var (
+	ErrCorruptSearchIndex = errors.New("corrupt search index")
+)
+
+// searchIndexLock serializes writes to search indexes, the logger appends
+// to a month's index from the logs of two days around midnight
+var searchIndexLock sync.Mutex
+
+// SearchIndexPath search index path of a month directory
+func SearchIndexPath(dir string) string {
+	return filepath.Join(dir, SearchIndexFileName)
+}
+
+// DaySearchIndexPath index of the day log at path the logger appends to
+// while it's written. Its segments are merged into the month's index when
+// the log is closed, leaving only the position indexing stopped at.
+func DaySearchIndexPath(path string) string {
+	return strings.TrimSuffix(path, ".txt") + ".search.idx"
+}
+
+// Tokenize lower case words of text, words are runs of letters, digits and
+// underscores
+func Tokenize(text string) []string {
+	var words []string
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			if start == -1 {
+				start = i
+			}
+			continue
+		}
+		if start != -1 {
+			words = appendWord(words, text[start:i])
+			start = -1
+		}
+	}
+	if start != -1 {
+		words = appendWord(words, text[start:])
+	}
+	return words
+}
+
+func appendWord(words []string, w string) []string {
+	if len(w) > MaxSearchTerm {
+		return words
+	}
+	return append(words, strings.ToLower(w))
+}
+
+// nickTerm term the nick of each line is indexed under, @ never appears in
+// words so it can't collide with one
+func nickTerm(nick string) string {
+	return "@" + strings.ToLower(nick)
+}
+
+// SearchSegment postings of consecutive lines of a day log, line numbers
+// in the postings are relative to Base
+type SearchSegment struct {
+	// Day day of the month
+	Day int
+	// Base line number of the segment's first line in the day log
+	Base     int
+	Lines    int
+	postings map[string][]uint32
+}
+
+// NewSearchSegment new segment starting at line base of day
+func NewSearchSegment(day, base int) *SearchSegment {
+	return &SearchSegment{
+		Day:      day,
+		Base:     base,
+		postings: map[string][]uint32{},
+	}
+}
+
+// Add indexes the next line of the day log, lines that aren't messages only
+// advance the line count
+func (s *SearchSegment) Add(line []byte) {
+	n := uint32(s.Lines)
+	s.Lines++
+	msg, err := ParseMessageLine(string(bytes.TrimSuffix(line, []byte("\n"))))
+	if err != nil {
+		return
+	}
+	s.add(nickTerm(msg.Nick), n)
+	for _, w := range Tokenize(msg.Data) {
+		s.add(w, n)
+	}
+}
+
+func (s *SearchSegment) add(term string, n uint32) {
+	p := s.postings[term]
+	if len(p) != 0 && p[len(p)-1] == n {
+		return
+	}
+	s.postings[term] = append(p, n)
+}
+
+// encode segment header followed by the compressed term list. Each term is
+// followed by its posting count, the size of its postings and the delta
+// encoded postings so lookups can skip terms they don't need.
+func (s *SearchSegment) encode() ([]byte, error) {
+	terms := make([]string, 0, len(s.postings))
+	for t := range s.postings {
+		terms = append(terms, t)
+	}
+	sort.Strings(terms)
+
+	var buf, postings bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+	putUvarint := func(b *bytes.Buffer, v uint64) {
+		b.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+	}
+	putUvarint(&buf, uint64(len(terms)))
+	for _, t := range terms {
+		p := s.postings[t]
+		postings.Reset()
+		var prev uint32
+		for _, n := range p {
+			putUvarint(&postings, uint64(n-prev))
+			prev = n
+		}
+		putUvarint(&buf, uint64(len(t)))
+		buf.WriteString(t)
+		putUvarint(&buf, uint64(len(p)))
+		putUvarint(&buf, uint64(postings.Len()))
+		buf.Write(postings.Bytes())
+	}
+
+	payload, err := zstd.Compress(nil, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, searchSegmentHeader, searchSegmentHeader+len(payload))
+	binary.BigEndian.PutUint32(data[0:], uint32(s.Day))
+	binary.BigEndian.PutUint32(data[4:], uint32(s.Base))
+	binary.BigEndian.PutUint32(data[8:], uint32(s.Lines))
+	binary.BigEndian.PutUint32(data[12:], uint32(len(payload)))
+	return append(data, payload...), nil
+}
+
+// searchSegmentData segment read from an index, the payload is only
+// decompressed when the segment is searched
+type searchSegmentData struct {
+	Day, Base, Lines int
+	payload          []byte
+}
+
+// postings decodes the postings of terms, or of every term if terms is nil
+func (s *searchSegmentData) postings(terms map[string]struct{}) (map[string][]uint32, error) {
+	data, err := zstd.Decompress(nil, s.payload)
+	if err != nil {
+		return nil, err
+	}
+	var off int
+	uvarint := func() uint64 {
+		if off < 0 {
+			return 0
+		}
+		v, n := binary.Uvarint(data[off:])
+		if n <= 0 {
+			off = -1
+			return 0
+		}
+		off += n
+		return v
+	}
+	count := uvarint()
+	res := map[string][]uint32{}
+	for i := uint64(0); i < count && off >= 0; i++ {
+		size := int(uvarint())
+		if off < 0 || size > len(data)-off {
+			return nil, ErrCorruptSearchIndex
+		}
+		term := string(data[off : off+size])
+		off += size
+		n := uvarint()
+		size = int(uvarint())
+		if off < 0 || size > len(data)-off || n > uint64(size) {
+			return nil, ErrCorruptSearchIndex
+		}
+		end := off + size
+		if _, ok := terms[term]; ok || terms == nil {
+			p := make([]uint32, 0, n)
+			var prev uint32
+			for j := uint64(0); j < n; j++ {
+				prev += uint32(uvarint())
+				p = append(p, prev)
+			}
+			if off != end {
+				return nil, ErrCorruptSearchIndex
+			}
+			res[term] = p
+		}
+		off = end
+	}
+	if off < 0 {
+		return nil, ErrCorruptSearchIndex
+	}
+	return res, nil
+}
+
+// truncate drops the lines from n on
+func (s *SearchSegment) truncate(n int) {
+	for t, p := range s.postings {
+		i := sort.Search(len(p), func(i int) bool { return p[i] >= uint32(n) })
+		if i == 0 {
+			delete(s.postings, t)
+		} else {
+			s.postings[t] = p[:i]
+		}
+	}
+	s.Lines = n
+}
+
+// decode the whole segment
+func (s *searchSegmentData) decode() (*SearchSegment, error) {
+	postings, err := s.postings(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &SearchSegment{Day: s.Day, Base: s.Base, Lines: s.Lines, postings: postings}, nil
+}
+
+// parseSearchIndex splits an index into its segments, n is the size of the
+// intact part of data which is less than its length if the last segment is
+// torn
+func parseSearchIndex(data []byte) (segs []searchSegmentData, n int, err error) {
+	for n < len(data) {
+		s, size, err := parseSearchSegment(data[n:])
+		if err != nil {
+			return segs, n, err
+		}
+		segs = append(segs, s)
+		n += size
+	}
+	return segs, n, nil
+}
+
+// parseSearchSegment parses the segment data starts with and returns its
+// size
+func parseSearchSegment(data []byte) (searchSegmentData, int, error) {
+	if len(data) < searchSegmentHeader {
+		return searchSegmentData{}, 0, ErrCorruptSearchIndex
+	}
+	s := searchSegmentData{
+		Day:   int(binary.BigEndian.Uint32(data[0:])),
+		Base:  int(binary.BigEndian.Uint32(data[4:])),
+		Lines: int(binary.BigEndian.Uint32(data[8:])),
+	}
+	size := int(binary.BigEndian.Uint32(data[12:]))
+	if s.Day < 1 || s.Day > 31 || size > len(data)-searchSegmentHeader {
+		return searchSegmentData{}, 0, ErrCorruptSearchIndex
+	}
+	s.payload = data[searchSegmentHeader : searchSegmentHeader+size]
+	return s, searchSegmentHeader + size, nil
+}
+
+// daySearchSegment segment of a day index, each one is prefixed with the
+// size of the day log its last line ends at
+type daySearchSegment struct {
+	searchSegmentData
+	End int64
+}
+
+// parseDaySearchIndex splits a day index into its segments, n is the size of
+// the intact part of data like in parseSearchIndex
+func parseDaySearchIndex(data []byte) (segs []daySearchSegment, n int, err error) {
+	for n < len(data) {
+		if len(data)-n < 8 {
+			return segs, n, ErrCorruptSearchIndex
+		}
+		s, size, err := parseSearchSegment(data[n+8:])
+		if err != nil {
+			return segs, n, err
+		}
+		segs = append(segs, daySearchSegment{s, int64(binary.BigEndian.Uint64(data[n:]))})
+		n += 8 + size
+	}
+	return segs, n, nil
+}
+
+// encodeDaySegment encodes s for a day index, end is the size of the day log
+// after the segment's last line
+func encodeDaySegment(s *SearchSegment, end int64) ([]byte, error) {
+	data, err := s.encode()
+	if err != nil {
+		return nil, err
+	}
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], uint64(end))
+	return append(h[:], data...), nil
+}
+
+// readSearchIndex segments of a month's index and of the day indexes of the
+// logs being written, a torn last segment is ignored since it may be an
+// append in progress
+func readSearchIndex(dir string) ([]searchSegmentData, error) {
+	var segs []searchSegmentData
+	f, err := Store().Open(SearchIndexPath(dir))
+	if err == nil {
+		data, rerr := ioutil.ReadAll(f)
+		f.Close()
+		if rerr != nil {
+			return nil, rerr
+		}
+		segs, _, _ = parseSearchIndex(data)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// day indexes are only written to local disk
+	names, _ := FileStore{}.ReadDir(dir)
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".search.idx") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		days, _, _ := parseDaySearchIndex(data)
+		for _, s := range days {
+			if s.Lines != 0 {
+				segs = append(segs, s.searchSegmentData)
+			}
+		}
+	}
+	if segs == nil && err != nil {
+		return nil, err
+	}
+	return segs, nil
+}
+
+// mergeSearchSegments merges segments of a day into one, it returns nil if
+// they have no lines
+func mergeSearchSegments(day int, segs []searchSegmentData) (*SearchSegment, error) {
+	sort.SliceStable(segs, func(i, j int) bool { return segs[i].Base < segs[j].Base })
+	var merged *SearchSegment
+	for _, s := range segs {
+		if s.Lines == 0 {
+			continue
+		}
+		if merged == nil {
+			merged = NewSearchSegment(day, s.Base)
+		}
+		// segments overlapping the ones merged already were indexed twice
+		if s.Base < merged.Base+merged.Lines {
+			continue
+		}
+		seg, err := s.decode()
+		if err != nil {
+			return nil, err
+		}
+		off := uint32(seg.Base - merged.Base)
+		for t, p := range seg.postings {
+			for _, n := range p {
+				merged.postings[t] = append(merged.postings[t], n+off)
+			}
+		}
+		merged.Lines = seg.Base + seg.Lines - merged.Base
+	}
+	return merged, nil
+}
+
+// appendSearchSegment appends a segment to the index of a month directory,
+// the caller holds searchIndexLock
+func appendSearchSegment(dir string, s *SearchSegment) error {
+	data, err := s.encode()
+	if err != nil {
+		return err
+	}
+	if err := repairSearchIndex(SearchIndexPath(dir)); err != nil {
+		return err
+	}
+	w, err := Store().Append(SearchIndexPath(dir))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// BuildSearchIndex indexes the day logs of a month directory, replacing its
+// search index
+func BuildSearchIndex(dir string) error {
+	names, err := Store().ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	days := map[string]struct{}{}
+	for _, name := range names {
+		if strings.HasSuffix(name, ".txt") || strings.HasSuffix(name, ".txt.gz") {
+			days[strings.TrimSuffix(name, ".gz")] = struct{}{}
+		}
+	}
+	sorted := make([]string, 0, len(days))
+	for day := range days {
+		sorted = append(sorted, day)
+	}
+	sort.Strings(sorted)
+
+	w, err := Store().Create(SearchIndexPath(dir))
+	if err != nil {
+		return err
+	}
+	for _, day := range sorted {
+		if err := indexDayLog(w, filepath.Join(dir, day)); err != nil {
+			abortWrite(w)
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// indexDayLog writes the segments of a day log to w
+func indexDayLog(w io.Writer, path string) error {
+	t, err := time.Parse("2006-01-02.txt", filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	r, err := OpenLog(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	write := func(s *SearchSegment) error {
+		if s.Lines == 0 {
+			return nil
+		}
+		data, err := s.encode()
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	s := NewSearchSegment(t.Day(), 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		s.Add(scanner.Bytes())
+		if s.Lines == SearchSegmentLines {
+			if err := write(s); err != nil {
+				return err
+			}
+			s = NewSearchSegment(s.Day, s.Base+s.Lines)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return write(s)
+}
+
+// SearchIndexWriter indexes the lines of a day log as they're written, they
+// are appended to the day's index when it's flushed
+type SearchIndexWriter struct {
+	dir  string
+	path string
+	// end size of the day log after the pending lines
+	end     int64
+	pending *SearchSegment
+}
+
+// NewSearchIndexWriter writer for the local day log at path. Lines the log
+// has past the position the day index stopped at, like ones written before
+// a crash, are indexed first. Lines indexed past the end of a log trimmed by
+// recovery are dropped from the day index.
+func NewSearchIndexWriter(path string) (*SearchIndexWriter, error) {
+	t, err := time.Parse("2006-01-02.txt", filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	w := &SearchIndexWriter{
+		dir:  filepath.Dir(path),
+		path: DaySearchIndexPath(path),
+	}
+	segs, err := repairDaySearchIndex(w.path)
+	if err != nil {
+		return nil, err
+	}
+	indexed, end := 0, int64(0)
+	if len(segs) != 0 {
+		last := segs[len(segs)-1]
+		indexed, end = last.Base+last.Lines, last.End
+	} else if month, err := readSearchIndex(w.dir); err == nil {
+		// indexes built by the tool don't record positions
+		for _, s := range month {
+			if s.Day == t.Day() && s.Base+s.Lines > indexed {
+				indexed, end = s.Base+s.Lines, -1
+			}
+		}
+	}
+	w.pending = NewSearchSegment(t.Day(), indexed)
+	w.end = end
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return w, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if end >= 0 && end <= info.Size() {
+		if _, err := f.Seek(end, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return w, w.indexFrom(f, 0)
+	}
+
+	// the position is unknown or past the end of the log, lines are counted
+	// from the start
+	w.end = 0
+	w.pending = NewSearchSegment(t.Day(), 0)
+	if err := w.indexFrom(f, indexed); err != nil {
+		return nil, err
+	}
+	if n := w.pending.Base + w.pending.Lines; n < indexed {
+		log.Printf("search index of %s is ahead of the log, %d lines indexed of %d", path, indexed, n)
+		if err := w.trim(segs, n); err != nil {
+			return nil, err
+		}
+		w.pending = NewSearchSegment(t.Day(), n)
+	}
+	return w, nil
+}
+
+// indexFrom adds the lines of r to the pending segment, the first skip
+// lines are only counted
+func (w *SearchIndexWriter) indexFrom(r io.Reader, skip int) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			// recovery trims torn lines before the log is opened
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		w.end += int64(len(line))
+		if skip > 0 {
+			skip--
+			w.pending.Base++
+			continue
+		}
+		w.pending.Add(line)
+	}
+}
+
+// trim rewrites the day index without the lines from n on, the log ends
+// after line n at w.end
+func (w *SearchIndexWriter) trim(segs []daySearchSegment, n int) error {
+	var buf bytes.Buffer
+	for _, s := range segs {
+		if s.Base >= n {
+			break
+		}
+		if s.Base+s.Lines > n {
+			seg, err := s.decode()
+			if err != nil {
+				return err
+			}
+			seg.truncate(n - s.Base)
+			s.End = w.end
+			data, err := encodeDaySegment(seg, s.End)
+			if err != nil {
+				return err
+			}
+			buf.Write(data)
+			continue
+		}
+		writeDaySegmentData(&buf, s)
+	}
+	searchIndexLock.Lock()
+	defer searchIndexLock.Unlock()
+	_, err := WriteFileAtomic(w.path, buf.Bytes())
+	return err
+}
+
+func writeDaySegmentData(w *bytes.Buffer, s daySearchSegment) {
+	var h [8 + searchSegmentHeader]byte
+	binary.BigEndian.PutUint64(h[0:], uint64(s.End))
+	binary.BigEndian.PutUint32(h[8:], uint32(s.Day))
+	binary.BigEndian.PutUint32(h[12:], uint32(s.Base))
+	binary.BigEndian.PutUint32(h[16:], uint32(s.Lines))
+	binary.BigEndian.PutUint32(h[20:], uint32(len(s.payload)))
+	w.Write(h[:])
+	w.Write(s.payload)
+}
+
+// repairDaySearchIndex truncates a segment torn by a crash from the end of a
+// day index so segments appended after it can be read
+func repairDaySearchIndex(path string) ([]daySearchSegment, error) {
+	searchIndexLock.Lock()
+	defer searchIndexLock.Unlock()
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	segs, n, err := parseDaySearchIndex(data)
+	if err != nil {
+		log.Printf("truncating torn search index %s at %d of %d bytes", path, n, len(data))
+		if err := os.Truncate(path, int64(n)); err != nil {
+			return nil, err
+		}
+	}
+	return segs, nil
+}
+
+// repairSearchIndex truncates a segment torn by a crash from the end of a
+// local month index, only the segment headers are read
+func repairSearchIndex(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var n int64
+	var h [searchSegmentHeader]byte
+	for n+searchSegmentHeader <= info.Size() {
+		if _, err := f.ReadAt(h[:], n); err != nil {
+			return err
+		}
+		day := binary.BigEndian.Uint32(h[0:])
+		size := int64(binary.BigEndian.Uint32(h[12:]))
+		if day < 1 || day > 31 || size > info.Size()-n-searchSegmentHeader {
+			break
+		}
+		n += searchSegmentHeader + size
+	}
+	if n == info.Size() {
+		return nil
+	}
+	log.Printf("truncating torn search index %s at %d of %d bytes", path, n, info.Size())
+	return f.Truncate(n)
+}
+
+// Add indexes the next line written to the log
+func (w *SearchIndexWriter) Add(line string) {
+	w.pending.Add([]byte(line))
+	w.end += int64(len(line))
+}
+
+// Flush appends the lines added since the last flush to the day index,
+// they're kept for the next flush if it fails
+func (w *SearchIndexWriter) Flush() error {
+	if w.pending.Lines == 0 {
+		return nil
+	}
+	data, err := encodeDaySegment(w.pending, w.end)
+	if err != nil {
+		return err
+	}
+	searchIndexLock.Lock()
+	defer searchIndexLock.Unlock()
+	if err := appendFile(w.path, data); err != nil {
+		return err
+	}
+	w.pending = NewSearchSegment(w.pending.Day, w.pending.Base+w.pending.Lines)
+	return nil
+}
+
+// Close flushes the writer, appends the day index merged into one segment
+// to the month's index and leaves the position indexing stopped at in the
+// day index for the next writer
+func (w *SearchIndexWriter) Close() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	searchIndexLock.Lock()
+	defer searchIndexLock.Unlock()
+	data, err := ioutil.ReadFile(w.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	days, _, _ := parseDaySearchIndex(data)
+	segs := make([]searchSegmentData, len(days))
+	for i, s := range days {
+		segs[i] = s.searchSegmentData
+	}
+	merged, err := mergeSearchSegments(w.pending.Day, segs)
+	if err != nil || merged == nil {
+		return err
+	}
+	if err := appendSearchSegment(w.dir, merged); err != nil {
+		return err
+	}
+	pos, err := encodeDaySegment(NewSearchSegment(w.pending.Day, w.pending.Base), w.end)
+	if err != nil {
+		return err
+	}
+	_, err = WriteFileAtomic(w.path, pos)
+	return err
+}
+
+// appendFile appends data to the local file at path
+func appendFile(path string, data []byte) error {
+	w, err := FileStore{}.Append(path)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
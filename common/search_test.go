@@ -0,0 +1,292 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	got := strings.Join(Tokenize("Hello, WORLD! don't @some_user PepeLaugh 42 "+strings.Repeat("x", MaxSearchTerm+1)), ",")
+	if want := "hello,world,don,t,some_user,pepelaugh,42"; got != want {
+		t.Errorf("invalid tokens, got: %s; want: %s", got, want)
+	}
+}
+
+func TestSearchQueryMatch(t *testing.T) {
+	cases := []struct {
+		q, nick, line string
+		match         bool
+	}{
+		{"pepe laugh", "", "foo: laugh at pepe", true},
+		{`"pepe laugh"`, "", "foo: laugh at pepe", false},
+		{`"pepe laugh"`, "", "foo: Pepe Laugh", true},
+		{"pepe OR kappa", "", "foo: Kappa", true},
+		{"pepe OR kappa dog", "", "foo: Kappa", false},
+		{"pepe -kappa", "", "foo: pepe Kappa", false},
+		{"pepe NOT kappa", "", "foo: pepe", true},
+		{"pepe", "bar", "foo: pepe", false},
+		{"", "FOO", "foo: anything", true},
+	}
+	for _, c := range cases {
+		q, err := ParseSearchQuery(c.q, c.nick)
+		if err != nil {
+			t.Errorf("error parsing %q %s", c.q, err)
+			continue
+		}
+		i := strings.Index(c.line, ": ")
+		if got := q.Match(c.line[:i], c.line[i+2:]); got != c.match {
+			t.Errorf("%q nick %q on %q, got: %t; want: %t", c.q, c.nick, c.line, got, c.match)
+		}
+	}
+	for _, q := range []string{"", "-pepe", "!!!"} {
+		if _, err := ParseSearchQuery(q, ""); err != ErrEmptySearch {
+			t.Errorf("expected empty search error for %q, got: %v", q, err)
+		}
+	}
+	if _, err := ParseSearchQuery(strings.Repeat("word ", MaxSearchWords+1), ""); err != ErrTooManyWords {
+		t.Errorf("expected too many words error, got: %v", err)
+	}
+}
+
+func searchAll(t *testing.T, dir, q, nick string) []string {
+	query, err := ParseSearchQuery(q, nick)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	if _, err := SearchMonth(dir, query, time.Time{}, time.Time{}, func(r *SearchResult) bool {
+		found = append(found, fmt.Sprintf("%s:%d", filepath.Base(r.Path), r.Line))
+		return true
+	}); err != nil {
+		t.Fatalf("error searching %s", err)
+	}
+	return found
+}
+
+func TestSearchIndex(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Test chatlog", "January 2017")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []int{3, 10} {
+		day := time.Date(2017, 1, d, 8, 0, 0, 0, time.UTC)
+		path := filepath.Join(dir, day.Format("2006-01-02")+".txt")
+		if err := ioutil.WriteFile(path, []byte(testDayLog(day, 2500)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// frames of the compressed day are read on their own
+	if _, err := CompressFile(filepath.Join(dir, "2017-01-03.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := BuildSearchIndex(dir); err != nil {
+		t.Fatalf("error building index %s", err)
+	}
+
+	got := strings.Join(searchAll(t, dir, "message 31", ""), ",")
+	if want := "2017-01-10.txt:1,2017-01-03.txt:1"; got != want {
+		t.Errorf("invalid results, got: %s; want: %s", got, want)
+	}
+	found := searchAll(t, dir, "pepelaugh", "nick5")
+	if len(found) != 2*(2500/23+1) || found[0] != "2017-01-10.txt:2489" {
+		t.Errorf("invalid nick results, got: %d %v", len(found), found[:1])
+	}
+	got = strings.Join(searchAll(t, dir, "31 OR 62 -message", ""), ",")
+	if got != "" {
+		t.Errorf("expected no results, got: %s", got)
+	}
+	if found := searchAll(t, dir, `"message 2480" OR "message 77469"`, ""); len(found) != 4 || found[0] != "2017-01-10.txt:2499" {
+		t.Errorf("invalid phrase results, got: %v", found)
+	}
+}
+
+func TestSearchIndexWriter(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Test chatlog", "January 2017")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2017, 1, 10, 8, 0, 0, 0, time.UTC)
+	path := filepath.Join(dir, "2017-01-10.txt")
+	lines := strings.SplitAfter(testDayLog(day, 30), "\n")
+
+	// lines written before the writer was opened are caught up
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines[:10], "")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewSearchIndexWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	write := func(from, to int) {
+		for _, l := range lines[from:to] {
+			f.WriteString(l)
+			w.Add(l)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(10, 15)
+	write(15, 20)
+
+	// a torn append is dropped and the lines it held are indexed again
+	idx, err := os.OpenFile(DaySearchIndexPath(path), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Write([]byte{0, 0, 0, 10, 0, 0})
+	idx.Close()
+	for _, l := range lines[20:25] {
+		f.WriteString(l)
+	}
+	if w, err = NewSearchIndexWriter(path); err != nil {
+		t.Fatal(err)
+	}
+	write(25, 30)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segs, err := readSearchIndex(dir)
+	if err != nil || len(segs) != 1 || segs[0].Lines != 30 {
+		t.Fatalf("expected one compacted segment, got: %+v %v", segs, err)
+	}
+	found := searchAll(t, dir, "", "nick0")
+	if strings.Join(found, ",") != "2017-01-10.txt:23,2017-01-10.txt:0" {
+		t.Errorf("invalid results, got: %v", found)
+	}
+	for i := 0; i < 30; i++ {
+		if found := searchAll(t, dir, fmt.Sprintf(`"message %d"`, i*31), ""); len(found) != 1 {
+			t.Errorf("line %d not found, got: %v", i, found)
+		}
+	}
+}
+
+// writeDay writes lines to the day log at path and indexes them with w
+func writeDay(t *testing.T, w *SearchIndexWriter, path string, lines []string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, l := range lines {
+		f.WriteString(l)
+		w.Add(l)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchIndexWriterPosition(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Test chatlog", "January 2017")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	other := NewSearchSegment(3, 0)
+	other.Add([]byte("[2017-01-03 08:00:00 UTC] nick0: other day\n"))
+	if err := appendSearchSegment(dir, other); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "2017-01-10.txt")
+	lines := strings.SplitAfter(testDayLog(time.Date(2017, 1, 10, 8, 0, 0, 0, time.UTC), 40), "\n")
+
+	w, err := NewSearchIndexWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeDay(t, w, path, lines[:10])
+	writeDay(t, w, path, lines[10:30])
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	closed, err := ioutil.ReadFile(SearchIndexPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the log isn't read again up to the position the day index stopped at
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := len(strings.Join(lines[:10], ""))
+	joined := []byte(strings.Replace(string(data[:head]), "\n", " ", -1) + string(data[head:]))
+	if err := ioutil.WriteFile(path, joined, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if w, err = NewSearchIndexWriter(path); err != nil {
+		t.Fatal(err)
+	}
+	if w.pending.Base != 30 || w.end != int64(len(data)) {
+		t.Fatalf("invalid position, got: %d lines %d bytes", w.pending.Base, w.end)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeDay(t, w, path, lines[30:35])
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// closing a day only appends to the month's index
+	month, err := ioutil.ReadFile(SearchIndexPath(dir))
+	if err != nil || !strings.HasPrefix(string(month), string(closed)) {
+		t.Fatalf("month index was rewritten, got: %d bytes %v", len(month), err)
+	}
+	segs, _, err := parseSearchIndex(month)
+	if err != nil || len(segs) != 3 || segs[1].Base != 0 || segs[1].Lines != 30 || segs[2].Base != 30 || segs[2].Lines != 5 {
+		t.Errorf("expected a segment per close, got: %+v %v", segs, err)
+	}
+	if found := searchAll(t, dir, `"message 1054"`, ""); strings.Join(found, ",") != "2017-01-10.txt:34" {
+		t.Errorf("invalid results, got: %v", found)
+	}
+}
+
+func TestSearchIndexWriterTrim(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Test chatlog", "January 2017")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "2017-01-10.txt")
+	lines := strings.SplitAfter(testDayLog(time.Date(2017, 1, 10, 8, 0, 0, 0, time.UTC), 30), "\n")
+
+	w, err := NewSearchIndexWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeDay(t, w, path, lines[:10])
+	writeDay(t, w, path, lines[10:20])
+
+	// recovery dropped lines the index was flushed with
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines[:15], "")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if w, err = NewSearchIndexWriter(path); err != nil {
+		t.Fatal(err)
+	}
+	if w.pending.Base != 15 {
+		t.Fatalf("expected indexing to continue at line 15, got: %d", w.pending.Base)
+	}
+	if found := searchAll(t, dir, `"message 527"`, ""); len(found) != 0 {
+		t.Errorf("trimmed line 17 was found, got: %v", found)
+	}
+	writeDay(t, w, path, lines[20:25])
+	if found := searchAll(t, dir, `"message 620"`, ""); strings.Join(found, ",") != "2017-01-10.txt:15" {
+		t.Errorf("invalid results for a line written after trimming, got: %v", found)
+	}
+	if found := searchAll(t, dir, `"message 434"`, ""); strings.Join(found, ",") != "2017-01-10.txt:14" {
+		t.Errorf("invalid results for a kept line, got: %v", found)
+	}
+}
@@ -0,0 +1,373 @@
+package common
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxSearchWords most words a search may have
+const MaxSearchWords = 16
+
+// errors
+var (
+	ErrEmptySearch  = errors.New("search needs a word, phrase or nick to look for")
+	ErrTooManyWords = errors.New("search has too many words")
+)
+
+// SearchQuery parsed search. Words are and'ed, OR between two words matches
+// either, a leading - or NOT excludes a word and quoted words have to appear
+// in order.
+type SearchQuery struct {
+	// Nick only lines of the nick match, case insensitive
+	Nick    string
+	clauses []searchClause
+}
+
+// searchClause phrases any of which have to appear, or none if not is set
+type searchClause struct {
+	not bool
+	any []searchPhrase
+}
+
+// searchPhrase words that have to appear in order
+type searchPhrase []string
+
+// ParseSearchQuery parses q, nick is optional
+func ParseSearchQuery(q, nick string) (*SearchQuery, error) {
+	query := &SearchQuery{Nick: nick}
+	var words int
+	var or, not bool
+	for q = strings.TrimSpace(q); q != ""; q = strings.TrimSpace(q) {
+		neg := not
+		not = false
+		if q[0] == '-' && len(q) > 1 {
+			neg = true
+			q = q[1:]
+		}
+		var raw string
+		if q[0] == '"' {
+			end := strings.IndexByte(q[1:], '"')
+			if end == -1 {
+				raw, q = q[1:], ""
+			} else {
+				raw, q = q[1:end+1], q[end+2:]
+			}
+		} else {
+			end := strings.IndexAny(q, " \t")
+			if end == -1 {
+				end = len(q)
+			}
+			raw, q = q[:end], q[end:]
+			switch raw {
+			case "OR":
+				or = len(query.clauses) != 0
+				continue
+			case "AND":
+				continue
+			case "NOT":
+				not = true
+				continue
+			}
+		}
+
+		phrase := searchPhrase(Tokenize(raw))
+		if len(phrase) == 0 {
+			or = false
+			continue
+		}
+		if words += len(phrase); words > MaxSearchWords {
+			return nil, ErrTooManyWords
+		}
+		if last := len(query.clauses) - 1; or && !neg && !query.clauses[last].not {
+			query.clauses[last].any = append(query.clauses[last].any, phrase)
+		} else {
+			query.clauses = append(query.clauses, searchClause{not: neg, any: []searchPhrase{phrase}})
+		}
+		or = false
+	}
+
+	if nick == "" {
+		var positive bool
+		for _, c := range query.clauses {
+			positive = positive || !c.not
+		}
+		if !positive {
+			return nil, ErrEmptySearch
+		}
+	}
+	return query, nil
+}
+
+// Match checks a message against the query
+func (q *SearchQuery) Match(nick, text string) bool {
+	if q.Nick != "" && !strings.EqualFold(q.Nick, nick) {
+		return false
+	}
+	words := Tokenize(text)
+	for _, c := range q.clauses {
+		var found bool
+		for _, p := range c.any {
+			if p.in(words) {
+				found = true
+				break
+			}
+		}
+		if found == c.not {
+			return false
+		}
+	}
+	return true
+}
+
+func (p searchPhrase) in(words []string) bool {
+Words:
+	for i := 0; i+len(p) <= len(words); i++ {
+		for j, w := range p {
+			if words[i+j] != w {
+				continue Words
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// terms index terms the candidates of the query are looked up with
+func (q *SearchQuery) terms() map[string]struct{} {
+	terms := map[string]struct{}{}
+	if q.Nick != "" {
+		terms[nickTerm(q.Nick)] = struct{}{}
+	}
+	for _, c := range q.clauses {
+		if c.not {
+			continue
+		}
+		for _, p := range c.any {
+			for _, w := range p {
+				terms[w] = struct{}{}
+			}
+		}
+	}
+	return terms
+}
+
+// candidates lines of a segment that may match, exclusions and word order
+// are left to Match
+func (q *SearchQuery) candidates(postings map[string][]uint32) []uint32 {
+	var res []uint32
+	first := true
+	and := func(p []uint32) {
+		if first {
+			res, first = p, false
+		} else {
+			res = intersectPostings(res, p)
+		}
+	}
+	if q.Nick != "" {
+		and(postings[nickTerm(q.Nick)])
+	}
+	for _, c := range q.clauses {
+		if c.not {
+			continue
+		}
+		var any []uint32
+		for _, p := range c.any {
+			lines := postings[p[0]]
+			for _, w := range p[1:] {
+				lines = intersectPostings(lines, postings[w])
+			}
+			any = unionPostings(any, lines)
+		}
+		and(any)
+	}
+	return res
+}
+
+func intersectPostings(a, b []uint32) []uint32 {
+	var res []uint32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
+
+func unionPostings(a, b []uint32) []uint32 {
+	res := make([]uint32, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			res = append(res, a[i])
+			i++
+		case a[i] > b[j]:
+			res = append(res, b[j])
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	res = append(res, a[i:]...)
+	return append(res, b[j:]...)
+}
+
+// SearchResult line matching a search
+type SearchResult struct {
+	// Path day log the line is in
+	Path string
+	// Line line number in the day log, starting at 0
+	Line int
+	Time time.Time
+	Nick string
+	Text string
+}
+
+// SearchMonth calls fn with the lines of a month directory matching q,
+// newest first, until it returns false. Only days between from and to are
+// searched, zero bounds are open. Months without a search index and lines
+// the logger hasn't flushed to it yet aren't searched.
+func SearchMonth(dir string, q *SearchQuery, from, to time.Time, fn func(*SearchResult) bool) (bool, error) {
+	month, err := time.Parse("January 2006", filepath.Base(dir))
+	if err != nil {
+		return false, err
+	}
+	segs, err := readSearchIndex(dir)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	byDay := map[int][]searchSegmentData{}
+	for _, s := range segs {
+		date := month.AddDate(0, 0, s.Day-1)
+		if (!from.IsZero() && date.Before(from)) || (!to.IsZero() && date.After(to)) {
+			continue
+		}
+		byDay[s.Day] = append(byDay[s.Day], s)
+	}
+	days := make([]int, 0, len(byDay))
+	for d := range byDay {
+		days = append(days, d)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(days)))
+
+	terms := q.terms()
+	for _, d := range days {
+		seen := map[int]struct{}{}
+		var lines []int
+		for _, s := range byDay[d] {
+			postings, err := s.postings(terms)
+			if err != nil {
+				return false, err
+			}
+			for _, n := range q.candidates(postings) {
+				line := s.Base + int(n)
+				if _, ok := seen[line]; !ok {
+					seen[line] = struct{}{}
+					lines = append(lines, line)
+				}
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		sort.Ints(lines)
+
+		path := filepath.Join(dir, month.AddDate(0, 0, d-1).Format("2006-01-02")+".txt")
+		texts, err := ReadLogLines(path, lines)
+		if err != nil {
+			return false, err
+		}
+		for i := len(lines) - 1; i >= 0; i-- {
+			text, ok := texts[lines[i]]
+			if !ok {
+				continue
+			}
+			msg, err := ParseMessageLine(text)
+			if err != nil || !q.Match(msg.Nick, msg.Data) {
+				continue
+			}
+			res := &SearchResult{
+				Path: path,
+				Line: lines[i],
+				Time: msg.Time,
+				Nick: msg.Nick,
+				Text: text,
+			}
+			if !fn(res) {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// ReadLogLines reads the sorted line numbers of a day log, compressed logs
+// only decode the frames holding them
+func ReadLogLines(path string, lines []int) (map[int]string, error) {
+	res := make(map[int]string, len(lines))
+	if len(lines) == 0 {
+		return res, nil
+	}
+	idx, err := ReadLogIndex(path)
+	if err != nil || len(idx.Frames) == 0 {
+		r, err := OpenLog(path)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return res, scanLogLines(bufio.NewScanner(r), 0, lines, res)
+	}
+
+	for i, f := range idx.Frames {
+		for len(lines) != 0 && lines[0] < f.Line {
+			lines = lines[1:]
+		}
+		if len(lines) == 0 {
+			break
+		}
+		if lines[0] >= f.Line+f.Lines {
+			continue
+		}
+		r, err := OpenLogFrames(path, idx, i, i+1)
+		if err != nil {
+			return nil, err
+		}
+		err = scanLogLines(bufio.NewScanner(r), f.Line, lines, res)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// scanLogLines adds the wanted lines of s, which starts at line first, to res
+func scanLogLines(s *bufio.Scanner, first int, lines []int, res map[int]string) error {
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	i := sort.SearchInts(lines, first)
+	for n := first; i < len(lines) && s.Scan(); n++ {
+		if n == lines[i] {
+			res[n] = s.Text()
+			i++
+		}
+	}
+	return s.Err()
+}
@@ -0,0 +1,249 @@
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// compressed day log settings
+const (
+	// LogFrameLines max lines per independently decodable frame, frames also
+	// end on the hour
+	LogFrameLines = 1000
+	// LogCompressionLevel day logs are only compressed once so they're worth
+	// a slower level than the default
+	LogCompressionLevel = 9
+)
+
+// errors
+var (
+	ErrStaleLogIndex = errors.New("log index doesn't match the log")
+)
+
+// LogFrame zstd frame of a compressed log
+type LogFrame struct {
+	Offset int64     `json:"offset"`
+	Size   int64     `json:"size"`
+	Line   int       `json:"line"`
+	Lines  int       `json:"lines"`
+	Start  time.Time `json:"start"`
+}
+
+// LogIndex frame offsets of a compressed day log
+type LogIndex struct {
+	// Size compressed log size, the index is stale if the log was rewritten
+	Size   int64      `json:"size"`
+	Frames []LogFrame `json:"frames"`
+}
+
+// LogIndexPath index path of a day log
+func LogIndexPath(path string) string {
+	return strings.TrimSuffix(path, ".gz") + ".idx"
+}
+
+// ReadLogIndex reads the frame index of a compressed log
+func ReadLogIndex(path string) (*LogIndex, error) {
+	f, err := Store().Open(LogIndexPath(path))
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	idx := &LogIndex{}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, err
+	}
+	gz, err := Store().Open(gzPath(path))
+	if err != nil {
+		return nil, err
+	}
+	gz.Close()
+	if gz.Size() != idx.Size {
+		return nil, ErrStaleLogIndex
+	}
+	return idx, nil
+}
+
+// Lines total lines in the log
+func (idx *LogIndex) Lines() int {
+	if len(idx.Frames) == 0 {
+		return 0
+	}
+	last := idx.Frames[len(idx.Frames)-1]
+	return last.Line + last.Lines
+}
+
+// Between returns the range of frames holding lines from start until end,
+// the frame before the first one starting at start can end with lines
+// stamped start
+func (idx *LogIndex) Between(start, end time.Time) (from, to int) {
+	from = sort.Search(len(idx.Frames), func(i int) bool {
+		return !idx.Frames[i].Start.Before(start)
+	}) - 1
+	if from < 0 {
+		from = 0
+	}
+	to = sort.Search(len(idx.Frames), func(i int) bool {
+		return idx.Frames[i].Start.After(end)
+	})
+	if to < from {
+		to = from
+	}
+	return from, to
+}
+
+// rangeReader files that fetch a byte range in one request, ReadAt would
+// need one per read
+type rangeReader interface {
+	ReadRange(off, n int64) (io.Reader, error)
+}
+
+// OpenLogFrames streams the frames [from, to) of a compressed log
+func OpenLogFrames(path string, idx *LogIndex, from, to int) (io.ReadCloser, error) {
+	if from < 0 || to > len(idx.Frames) || from > to {
+		return nil, errors.New("frame out of range")
+	}
+	f, err := Store().Open(gzPath(path))
+	if err != nil {
+		return nil, err
+	}
+	if from == to {
+		return &compressedReader{ReadCloser: ioutil.NopCloser(strings.NewReader("")), f: f}, nil
+	}
+	offset := idx.Frames[from].Offset
+	last := idx.Frames[to-1]
+	var section io.Reader = io.NewSectionReader(f, offset, last.Offset+last.Size-offset)
+	if rf, ok := f.(rangeReader); ok {
+		section, err = rf.ReadRange(offset, last.Offset+last.Size-offset)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	r, err := newDecompressReader(section, logDictDir(path))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &compressedReader{ReadCloser: r, f: f}, nil
+}
+
+// compressSeekable compresses a day log into frames and writes its index,
+// the channel's latest dictionary is used if it has one
+func compressSeekable(src io.Reader, path string) error {
+	var dict []byte
+	d, err := LatestDict(logDictDir(path))
+	if err != nil {
+		return err
+	}
+	if d != nil {
+		dict = d.Data
+	}
+
+	f, err := createAtomic(gzPath(path))
+	if err != nil {
+		return err
+	}
+
+	idx := &LogIndex{}
+	var frame []byte
+	// frames without timestamped lines keep the previous frame's start
+	var frameStart time.Time
+	var timed bool
+	var line, lines int
+	flush := func() error {
+		if lines == 0 {
+			return nil
+		}
+		c, err := compressDict(frame, dict, LogCompressionLevel)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(c); err != nil {
+			return err
+		}
+		idx.Frames = append(idx.Frames, LogFrame{
+			Offset: idx.Size,
+			Size:   int64(len(c)),
+			Line:   line,
+			Lines:  lines,
+			Start:  frameStart,
+		})
+		idx.Size += int64(len(c))
+		line += lines
+		lines = 0
+		timed = false
+		frame = frame[:0]
+		return nil
+	}
+
+	r := bufio.NewReader(src)
+	for {
+		l, err := r.ReadBytes('\n')
+		if len(l) > 0 {
+			t, ok := logLineTime(l)
+			if lines > 0 && (lines >= LogFrameLines || (ok && timed && t.Truncate(time.Hour) != frameStart.Truncate(time.Hour))) {
+				if err := flush(); err != nil {
+					f.Abort()
+					return err
+				}
+			}
+			if ok && !timed {
+				frameStart, timed = t, true
+			}
+			frame = append(frame, l...)
+			lines++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Abort()
+			return err
+		}
+	}
+	if err := flush(); err != nil {
+		f.Abort()
+		return err
+	}
+	if err := f.Commit(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	_, err = WriteFileAtomic(LogIndexPath(path), data)
+	return err
+}
+
+// logLineTime parses the timestamp of a "[2006-01-02 15:04:05 MST] nick: message" line
+func logLineTime(line []byte) (time.Time, bool) {
+	const layout = "2006-01-02 15:04:05 MST"
+	if len(line) < len(layout)+2 || line[0] != '[' {
+		return time.Time{}, false
+	}
+	n := len(line)
+	if n > len(layout)+4 {
+		n = len(layout) + 4
+	}
+	end := strings.IndexByte(string(line[:n]), ']')
+	if end == -1 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(layout, string(line[1:end]))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t.UTC(), true
+}
@@ -0,0 +1,122 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeekableLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "2017-01-10.txt")
+	var b strings.Builder
+	start := time.Date(2017, 1, 10, 8, 0, 0, 0, time.UTC)
+	for i := 0; i < 2500; i++ {
+		ts := start.Add(time.Duration(i) * 3 * time.Second)
+		fmt.Fprintf(&b, "%snick: line %d\n", ts.Format("[2006-01-02 15:04:05 MST] "), i)
+	}
+	if err := ioutil.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompressFile(path); err != nil {
+		t.Fatalf("error compressing %s", err)
+	}
+
+	idx, err := ReadLogIndex(path)
+	if err != nil {
+		t.Fatalf("error reading index %s", err)
+	}
+	// 1000 line frames, split again on the hour at lines 1200 and 2400
+	if len(idx.Frames) != 5 || idx.Lines() != 2500 {
+		t.Fatalf("invalid index, got: %d frames %d lines", len(idx.Frames), idx.Lines())
+	}
+	if idx.Frames[2].Line != 1200 || !idx.Frames[2].Start.Equal(start.Add(time.Hour)) {
+		t.Errorf("expected frame at 09:00, got: %+v", idx.Frames[2])
+	}
+
+	from, to := idx.Between(start.Add(time.Hour+time.Minute), start.Add(time.Hour+2*time.Minute))
+	if from != 2 || to != 3 {
+		t.Errorf("invalid frame range, got: [%d, %d); want: [2, 3)", from, to)
+	}
+	r, err := OpenLogFrames(path, idx, from, to)
+	if err != nil {
+		t.Fatalf("error opening frames %s", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("error reading frames %s", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 1000 || !strings.HasSuffix(lines[0], "line 1200") {
+		t.Errorf("invalid frame data, got: %d lines starting with %q", len(lines), lines[0])
+	}
+
+	// the whole log still decodes as one stream
+	full, err := ReadCompressedFile(path)
+	if err != nil || string(full) != b.String() {
+		t.Errorf("invalid full decode, got: %d bytes %v", len(full), err)
+	}
+}
+
+// writeCompressed writes lines to a day log and compresses it
+func writeCompressed(t *testing.T, lines []string) (string, *LogIndex) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "2017-01-10.txt")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompressFile(path); err != nil {
+		t.Fatalf("error compressing %s", err)
+	}
+	idx, err := ReadLogIndex(path)
+	if err != nil {
+		t.Fatalf("error reading index %s", err)
+	}
+	return path, idx
+}
+
+func TestSeekableLogSameSecond(t *testing.T) {
+	start := time.Date(2017, 1, 10, 8, 0, 0, 0, time.UTC)
+	var lines []string
+	for i := 0; i < 1500; i++ {
+		lines = append(lines, fmt.Sprintf("%snick: line %d", start.Format("[2006-01-02 15:04:05 MST] "), i))
+	}
+	path, idx := writeCompressed(t, lines)
+	if len(idx.Frames) != 2 || !idx.Frames[1].Start.Equal(start) {
+		t.Fatalf("expected two frames starting at 08:00:00, got: %+v", idx.Frames)
+	}
+
+	from, to := idx.Between(start, start.Add(time.Minute))
+	if from != 0 || to != 2 {
+		t.Fatalf("invalid frame range, got: [%d, %d); want: [0, 2)", from, to)
+	}
+	r, err := OpenLogFrames(path, idx, from, to)
+	if err != nil {
+		t.Fatalf("error opening frames %s", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("error reading frames %s", err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 1500 {
+		t.Errorf("expected every line of the second, got: %d", n)
+	}
+}
+
+func TestSeekableLogUntimedLine(t *testing.T) {
+	start := time.Date(2017, 1, 10, 8, 0, 0, 0, time.UTC)
+	var lines []string
+	for i := 0; i < LogFrameLines; i++ {
+		lines = append(lines, fmt.Sprintf("%snick: line %d", start.Format("[2006-01-02 15:04:05 MST] "), i))
+	}
+	next := start.Add(30 * time.Minute)
+	lines = append(lines, "untimed line", next.Format("[2006-01-02 15:04:05 MST] ")+"nick: next frame")
+	_, idx := writeCompressed(t, lines)
+	if len(idx.Frames) != 2 || !idx.Frames[1].Start.Equal(next) {
+		t.Errorf("expected the second frame to start at 08:30:00, got: %+v", idx.Frames)
+	}
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// errors
+var (
+	ErrSingleChannel = errors.New("chat source only serves a single channel")
+)
+
+// ChatSource chat platform client
+type ChatSource interface {
+	// Run connects and starts reading messages in the background
+	Run()
+	// Stop disconnects and closes the message channel
+	Stop()
+	Join(ch string) error
+	Leave(ch string) error
+	Channels() []string
+	Messages() <-chan *Message
+}
+
+// ChatSourceFactory creates a chat source from its config
+type ChatSourceFactory func(SourceConfig) (ChatSource, error)
+
+var (
+	chatSourcesLock sync.RWMutex
+	chatSources     = map[string]ChatSourceFactory{}
+)
+
+// RegisterChatSource makes a chat source kind available to NewChatSource
+func RegisterChatSource(kind string, f ChatSourceFactory) {
+	chatSourcesLock.Lock()
+	defer chatSourcesLock.Unlock()
+	chatSources[kind] = f
+}
+
+// NewChatSource creates a chat source of the configured kind
+func NewChatSource(c SourceConfig) (ChatSource, error) {
+	chatSourcesLock.RLock()
+	f, ok := chatSources[c.Kind]
+	chatSourcesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown chat source kind %q", c.Kind)
+	}
+	return f(c)
+}
+
+var (
+	_ ChatSource = (*Destiny)(nil)
+	_ ChatSource = (*Twitch)(nil)
+	_ ChatSource = (*IRC)(nil)
+)
+
+func init() {
+	RegisterChatSource("destinygg", func(SourceConfig) (ChatSource, error) {
+		return NewDestiny(), nil
+	})
+	RegisterChatSource("twitch", func(SourceConfig) (ChatSource, error) {
+		return NewTwitch(), nil
+	})
+	RegisterChatSource("irc", func(c SourceConfig) (ChatSource, error) {
+		if c.Server == "" || c.Nick == "" {
+			return nil, errors.New("irc source requires a server and a nick")
+		}
+		return NewIRC(c), nil
+	})
+	RegisterChatSource("ircs", func(c SourceConfig) (ChatSource, error) {
+		if c.Server == "" || c.Nick == "" {
+			return nil, errors.New("irc source requires a server and a nick")
+		}
+		c.TLS = true
+		return NewIRC(c), nil
+	})
+}
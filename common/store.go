@@ -0,0 +1,422 @@
+package common
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ArchiveDelay how long after a month ended it's moved to the archive, the
+// last day is compressed and gap records are written a little after midnight
+const ArchiveDelay = 48 * time.Hour
+
+// errors
+var (
+	ErrAppendNotSupported = errors.New("store doesn't support appending")
+)
+
+// LogFile file opened from a LogStore
+type LogFile interface {
+	io.ReadCloser
+	io.ReaderAt
+	Size() int64
+}
+
+// LogStore storage holding the logs tree. Paths are the ones the tree has on
+// local disk so callers don't need to know which store a file lives in, and
+// missing files return errors os.IsNotExist recognizes.
+type LogStore interface {
+	// ReadDir lists the names in dir
+	ReadDir(dir string) ([]string, error)
+	Open(path string) (LogFile, error)
+	// Append opens a file for appending, creating it if needed
+	Append(path string) (io.WriteCloser, error)
+	// Create replaces a file with what's written to it once it's closed
+	Create(path string) (io.WriteCloser, error)
+	Remove(path string) error
+}
+
+var (
+	storeLock sync.RWMutex
+	store     LogStore = FileStore{}
+)
+
+// Store the store logs are read from
+func Store() LogStore {
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+	return store
+}
+
+// SetStore replaces the store logs are read from
+func SetStore(s LogStore) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	store = s
+}
+
+// NewStoreFromConfig local store, backed by the configured s3 archive if
+// there's one. root is the local logs path the archive mirrors.
+func NewStoreFromConfig(root string) LogStore {
+	conf := GetConfig()
+	if conf == nil || conf.S3.Bucket == "" {
+		return FileStore{}
+	}
+	return &TieredStore{
+		Local:   FileStore{},
+		Archive: NewS3Store(conf.S3, root),
+	}
+}
+
+// Channels sorted channel directories in root
+func Channels(s LogStore, root string) ([]string, error) {
+	names, err := s.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var channels []string
+	for _, name := range names {
+		if strings.HasSuffix(name, " chatlog") {
+			channels = append(channels, name)
+		}
+	}
+	sort.Strings(channels)
+	return channels, nil
+}
+
+// Months month directories of a channel, oldest first
+func Months(s LogStore, root, channel string) ([]string, error) {
+	names, err := s.ReadDir(filepath.Join(root, channel))
+	if err != nil {
+		return nil, err
+	}
+	var months []time.Time
+	for _, name := range names {
+		if t, err := time.Parse("January 2006", name); err == nil {
+			months = append(months, t)
+		}
+	}
+	sort.Slice(months, func(i, j int) bool { return months[i].Before(months[j]) })
+	res := make([]string, len(months))
+	for i, t := range months {
+		res[i] = t.Format("January 2006")
+	}
+	return res, nil
+}
+
+// Days sorted day log names of a month, compressed or not
+func Days(s LogStore, root, channel, month string) ([]string, error) {
+	names, err := s.ReadDir(filepath.Join(root, channel, month))
+	if err != nil {
+		return nil, err
+	}
+	var days []string
+	for _, name := range names {
+		if strings.HasSuffix(name, ".txt") || strings.HasSuffix(name, ".txt.gz") {
+			days = append(days, name)
+		}
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// FileStore logs on the local file system
+type FileStore struct{}
+
+// ReadDir implement LogStore
+func (FileStore) ReadDir(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(0)
+}
+
+// Open implement LogStore
+func (FileStore) Open(path string) (LogFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &localFile{File: f, size: info.Size()}, nil
+}
+
+// Append implement LogStore
+func (FileStore) Append(path string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+// Create implement LogStore
+func (FileStore) Create(path string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := createAtomic(path)
+	if err != nil {
+		return nil, err
+	}
+	return &committer{f}, nil
+}
+
+// Remove implement LogStore
+func (FileStore) Remove(path string) error {
+	return os.Remove(path)
+}
+
+type localFile struct {
+	*os.File
+	size int64
+}
+
+// Size file size when it was opened
+func (f *localFile) Size() int64 {
+	return f.size
+}
+
+// committer commits an atomic file on close
+type committer struct {
+	*atomicFile
+}
+
+// Close implement io.Closer
+func (c *committer) Close() error {
+	return c.Commit()
+}
+
+// TieredStore local store with older logs moved to an archive, reads fall
+// back to the archive and writes go to the local store
+type TieredStore struct {
+	Local   LogStore
+	Archive LogStore
+}
+
+// ReadDir lists the names in dir in either store
+func (s *TieredStore) ReadDir(dir string) ([]string, error) {
+	local, lerr := s.Local.ReadDir(dir)
+	archive, aerr := s.Archive.ReadDir(dir)
+	if lerr != nil && aerr != nil {
+		if !os.IsNotExist(lerr) {
+			return nil, lerr
+		}
+		return nil, aerr
+	}
+	if aerr != nil && !os.IsNotExist(aerr) {
+		log.Printf("error listing archived %s %s", dir, aerr)
+	}
+	seen := make(map[string]struct{}, len(local))
+	names := local
+	for _, name := range local {
+		seen[name] = struct{}{}
+	}
+	for _, name := range archive {
+		if _, ok := seen[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// Open opens the local file or its archived copy
+func (s *TieredStore) Open(path string) (LogFile, error) {
+	f, err := s.Local.Open(path)
+	if os.IsNotExist(err) {
+		return s.Archive.Open(path)
+	}
+	return f, err
+}
+
+// Append appends to the local file, an archived file is copied back first so
+// appending to a month after it was archived continues the archived copy
+func (s *TieredStore) Append(path string) (io.WriteCloser, error) {
+	f, err := s.Local.Open(path)
+	if err == nil {
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	} else if _, err := copyLogFile(s.Archive, s.Local, path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return s.Local.Append(path)
+}
+
+// Create implement LogStore
+func (s *TieredStore) Create(path string) (io.WriteCloser, error) {
+	return s.Local.Create(path)
+}
+
+// Remove removes the file from both stores
+func (s *TieredStore) Remove(path string) error {
+	lerr := s.Local.Remove(path)
+	aerr := s.Archive.Remove(path)
+	if lerr != nil && !os.IsNotExist(lerr) {
+		return lerr
+	}
+	if aerr != nil && !os.IsNotExist(aerr) {
+		return aerr
+	}
+	if lerr != nil && aerr != nil {
+		return lerr
+	}
+	return nil
+}
+
+// ArchiveMonths moves the months of every channel in root that ended at
+// least ArchiveDelay before now from local to archive
+func ArchiveMonths(local, archive LogStore, root string, now time.Time) error {
+	channels, err := Channels(local, root)
+	if err != nil {
+		return err
+	}
+	for _, ch := range channels {
+		months, err := Months(local, root, ch)
+		if err != nil {
+			log.Printf("error listing months of %s %s", ch, err)
+			continue
+		}
+		for _, m := range months {
+			t, _ := time.Parse("January 2006", m)
+			if now.Sub(t.AddDate(0, 1, 0)) < ArchiveDelay {
+				break
+			}
+			if err := ArchiveMonth(local, archive, filepath.Join(root, ch, m)); err != nil {
+				log.Printf("error archiving %s/%s %s", ch, m, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ArchiveMonth copies every file of a month directory to archive and
+// removes the local copies once they're all stored. Months with files that
+// are still being written, or that are appended to while they're copied,
+// are left in place for the next run.
+func ArchiveMonth(local, archive LogStore, dir string) error {
+	names, err := local.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		open, err := openForWriting(local, filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if open {
+			return errors.New("month has files still being written " + filepath.Join(dir, name))
+		}
+	}
+	sizes := make(map[string]int64, len(names))
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		size, err := copyLogFile(local, archive, path)
+		if err != nil {
+			return err
+		}
+		sizes[name] = size
+	}
+	for _, name := range names {
+		f, err := local.Open(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		f.Close()
+		if f.Size() != sizes[name] {
+			return errors.New("file was written while archiving " + filepath.Join(dir, name))
+		}
+	}
+	for _, name := range names {
+		if err := local.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	log.Printf("archived %s", dir)
+	return local.Remove(dir)
+}
+
+// openForWriting reports whether the file at path can still be appended to,
+// logs and their sidecars are compressed once they're closed and day search
+// indexes only keep the log position once they're merged
+func openForWriting(local LogStore, path string) (bool, error) {
+	name := filepath.Base(path)
+	switch {
+	case strings.HasSuffix(name, ".txt"), strings.Contains(name, ".tmp"):
+		return true, nil
+	case strings.HasSuffix(name, ".jsonl"):
+		// late gaps continue the archived copy
+		return name != GapsFileName, nil
+	case strings.HasSuffix(name, ".search.idx") && name != SearchIndexFileName:
+		f, err := local.Open(path)
+		if err != nil {
+			return false, err
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return false, err
+		}
+		segs, _, err := parseDaySearchIndex(data)
+		if err != nil {
+			return true, nil
+		}
+		for _, s := range segs {
+			if s.Lines != 0 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// abortWrite discards a file from Create instead of storing it
+func abortWrite(w io.WriteCloser) {
+	if a, ok := w.(interface{ Abort() }); ok {
+		a.Abort()
+		return
+	}
+	w.Close()
+}
+
+// copyLogFile copies path between stores, checks the copy's size and
+// returns it
+func copyLogFile(src, dst LogStore, path string) (int64, error) {
+	r, err := src.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	w, err := dst.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		abortWrite(w)
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	c, err := dst.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	c.Close()
+	if c.Size() != r.Size() {
+		return 0, errors.New("size mismatch copying " + path)
+	}
+	return c.Size(), nil
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -16,49 +15,57 @@ import (
 
 // Twitch twitch chat client
 type Twitch struct {
-	connLock       sync.Mutex
-	sendLock       sync.Mutex
-	conn           *websocket.Conn
-	ChLock         sync.RWMutex
-	channels       []string
-	messages       chan *Message
-	MessagePattern *regexp.Regexp
-	SubPattern     *regexp.Regexp
-	lastMessageMu  sync.RWMutex
-	lastMessage    time.Time
-	quit           chan struct{}
+	*connLoop
+	connLock      sync.Mutex
+	sendLock      sync.Mutex
+	conn          *websocket.Conn
+	ChLock        sync.RWMutex
+	channels      []string
+	messages      chan *Message
+	sink          *MessageSink
+	lastMessageMu sync.RWMutex
+	lastMessage   time.Time
+}
+
+// twitchSubTypes USERNOTICE msg-ids logged as twitchnotify lines, every other
+// msg-id is passed on as a USERNOTICE message
+var twitchSubTypes = map[string]struct{}{
+	"sub":             {},
+	"resub":           {},
+	"subgift":         {},
+	"giftpaidupgrade": {},
 }
 
 // NewTwitch new twitch chat client
 func NewTwitch() *Twitch {
-	return &Twitch{
+	c := &Twitch{
+		connLoop: newConnLoop("twitch"),
 		channels: make([]string, 0),
 		messages: make(chan *Message, MessageBufferSize),
-		// > @badges=global_mod/1,turbo/1;color=#0D4200;display-name=dallas;emotes=25:0-4,12-16/1902:6-10;mod=0;room-id=1337;
-		//subscriber=0;turbo=1;user-id=1337;user-type=global_mod :ronni!ronni@ronni.tmi.twitch.tv PRIVMSG #dallas :Kappa Keepo Kappa
-		MessagePattern: regexp.MustCompile(`user-type=.+:([a-z0-9_-]+)\!.+\.tmi\.twitch\.tv PRIVMSG #([a-z0-9_-]+) :(.+)`),
-		// > @badges=staff/1,broadcaster/1,turbo/1;color=#008000;display-name=ronni;emotes=;mod=0;msg-id=resub;msg-param-months=6;
-		// msg-param-sub-plan=Prime;msg-param-sub-plan-name=Prime;room-id=1337;subscriber=1;system-msg=ronni\shas\ssubscribed\sfor\s6\smonths!;
-		// login=ronni;turbo=1;user-id=1337;user-type=staff :tmi.twitch.tv USERNOTICE #dallas :Great stream -- keep it up!
-		SubPattern: regexp.MustCompile(`msg-id=(sub|resub|subgift|giftpaidupgrade);.+;system-msg=(.+);tmi-sent-ts.+ \:tmi\.twitch\.tv USERNOTICE #([a-z0-9_-]+)( :.+)?`),
-		quit:       make(chan struct{}, 2),
 	}
+	c.sink = NewMessageSink(c.messages, c.ctx.Done())
+	c.onGap = func(g Gap) {
+		pushGaps(c.sink, c.Channels(), g)
+	}
+	return c
 }
 
-func (c *Twitch) connect() {
+func (c *Twitch) connect() error {
 	conf := GetConfig()
 	dialer := websocket.Dialer{HandshakeTimeout: HandshakeTimeout}
 	headers := http.Header{"Origin": []string{conf.Twitch.OriginURL}}
 
-	var err error
-	c.connLock.Lock()
-	c.conn, _, err = dialer.Dial(GetConfig().Twitch.SocketURL, headers)
-	c.connLock.Unlock()
+	conn, _, err := dialer.DialContext(c.ctx, conf.Twitch.SocketURL, headers)
 	if err != nil {
-		log.Printf("error connecting to twitch ws %s", err)
-		c.reconnect()
-		return
+		return fmt.Errorf("error connecting to twitch ws %s", err)
 	}
+	c.connLock.Lock()
+	c.conn = conn
+	c.connLock.Unlock()
+
+	c.lastMessageMu.Lock()
+	c.lastMessage = time.Now()
+	c.lastMessageMu.Unlock()
 
 	if conf.Twitch.OAuth == "" || conf.Twitch.Nick == "" {
 		log.Println("missing OAuth or Nick, using justinfan659 as login data")
@@ -66,140 +73,208 @@ func (c *Twitch) connect() {
 		conf.Twitch.Nick = "justinfan659"
 	}
 
-	c.send("PASS " + conf.Twitch.OAuth)
-	c.send("NICK " + conf.Twitch.Nick)
-	c.send("CAP REQ :twitch.tv/tags")
-	c.send("CAP REQ :twitch.tv/commands")
+	for _, m := range []string{
+		"PASS " + conf.Twitch.OAuth,
+		"NICK " + conf.Twitch.Nick,
+		"CAP REQ :twitch.tv/tags",
+		"CAP REQ :twitch.tv/commands",
+	} {
+		if err := c.write(conn, m); err != nil {
+			return err
+		}
+	}
 
-	for _, ch := range c.channels {
-		select {
-		case <-c.quit:
-			return
-		default:
+	// channels joined after this are sent by Join
+	c.setState(StateConnected)
+	for _, ch := range c.Channels() {
+		if c.ctx.Err() != nil {
+			return c.ctx.Err()
 		}
-		ch = strings.ToLower(ch)
 		log.Printf("joining %s", ch)
-		err := c.send("JOIN #" + ch)
-		if err != nil {
-			log.Println("failed to join", ch, "after freshly re/connecting to the websocket")
+		if err := c.join(conn, ch); err != nil {
+			return fmt.Errorf("failed to join %s after freshly re/connecting to the websocket %s", ch, err)
 		}
 	}
+	return nil
 }
 
+// reconnect drops the current connection, the run loop reconnects after backing off
 func (c *Twitch) reconnect() {
 	c.connLock.Lock()
 	if c.conn != nil {
 		c.conn.Close()
 	}
 	c.connLock.Unlock()
-
-	time.Sleep(SocketReconnectDelay)
-	c.connect()
 }
 
 // Run connect and start message read loop
 func (c *Twitch) Run() {
-	pinger := time.NewTicker(4 * time.Minute)
-	c.connect()
-	go c.rejoinHandler()
-
-	pingTicker := time.NewTicker(5 * time.Minute)
-
-	go func() {
-		for {
-			select {
-			case <-c.quit:
-				close(c.messages)
+	const (
+		timeout        = 2 * time.Minute
+		pingInterval   = 5 * time.Minute
+		rejoinInterval = 2 * time.Hour
+	)
+	lastPing := time.Now()
+	lastRejoin := time.Now()
+	c.watchdog(time.Minute, func() {
+		if c.State() != StateConnected {
+			return
+		}
+		c.lastMessageMu.RLock()
+		idle := time.Since(c.lastMessage)
+		c.lastMessageMu.RUnlock()
+		if idle > timeout {
+			log.Println("twitch timeout triggered")
+			c.reconnect()
+			return
+		}
+		if time.Since(lastPing) > pingInterval {
+			lastPing = time.Now()
+			if err := c.send("PING"); err != nil {
+				log.Printf("error sending PING: %v", err)
+				c.reconnect()
 				return
-			case <-pingTicker.C:
-				err := c.send("PING")
-				if err != nil {
-					c.reconnect()
-					continue
-				}
-			default:
 			}
-			c.connLock.Lock()
-			err := c.conn.SetReadDeadline(time.Now().Add(SocketReadTimeout))
-			c.connLock.Unlock()
-			if err != nil {
-				log.Printf("error setting the ReadDeadline: %v", err)
-				c.reconnect()
-				continue
+		}
+		if time.Since(lastRejoin) > rejoinInterval {
+			lastRejoin = time.Now()
+			for _, ch := range c.Channels() {
+				if err := c.sendJoin(ch); err != nil {
+					log.Println(err)
+				}
 			}
+		}
+	})
+	go func() {
+		defer c.sink.Close()
+		c.run(c.connect, c.read, c.reconnect)
+	}()
+}
+
+func (c *Twitch) read() error {
+	c.connLock.Lock()
+	conn := c.conn
+	c.connLock.Unlock()
 
-			c.connLock.Lock()
-			_, msg, err := c.conn.ReadMessage()
-			c.connLock.Unlock()
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(SocketReadTimeout)); err != nil {
+			return fmt.Errorf("error setting the ReadDeadline: %v", err)
+		}
+
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("error reading message: %v", err)
+		}
+
+		for _, line := range SplitIRCLines(string(msg)) {
+			im, err := ParseIRCMessage(line)
 			if err != nil {
-				log.Printf("error reading message: %v", err)
-				c.reconnect()
+				log.Printf("error parsing message: %v", err)
 				continue
 			}
-
-			if strings.Index(string(msg), "PING") == 0 {
-				err := c.send("PONG")
-				if err != nil {
-					log.Printf("error sending PONG: %v", err)
-					c.reconnect()
+			if im.Command == "PING" {
+				if err := c.send("PONG :" + im.Trailing()); err != nil {
+					return fmt.Errorf("error sending PONG: %v", err)
 				}
 				continue
 			}
-
-			s := c.SubPattern.FindAllStringSubmatch(string(msg), -1)
-			for _, v := range s {
-				data := strings.Replace(v[2], "\\s", " ", -1)
-				if v[4] != "" {
-					data += " [SubMessage]: " + v[4][2:]
-				}
-				m := &Message{
-					Type:    "MSG",
-					Channel: v[3],
-					Nick:    "twitchnotify",
-					Data:    data,
-					Time:    time.Now().UTC(),
-				}
-
-				select {
-				case <-pinger.C:
-					c.send("PING :tmi.twitch.tv")
-				case c.messages <- m:
-				default:
-					log.Println("error messages channel full :(")
-				}
+			m := twitchMessage(im)
+			if m == nil {
+				continue
 			}
+			c.sink.Push(m)
+		}
 
-			l := c.MessagePattern.FindAllStringSubmatch(string(msg), -1)
-			for _, v := range l {
-				data := strings.TrimSpace(v[3])
-				data = strings.Replace(data, "ACTION", "/me", -1)
-				data = strings.Replace(data, "", "", -1)
-				m := &Message{
-					Type:    "MSG",
-					Channel: v[2],
-					Nick:    v[1],
-					Data:    data,
-					Time:    time.Now().UTC(),
-				}
+		c.lastMessageMu.Lock()
+		c.lastMessage = time.Now()
+		c.lastMessageMu.Unlock()
+	}
+}
 
-				select {
-				case <-pinger.C:
-					c.send("PING :tmi.twitch.tv")
-				case c.messages <- m:
-				default:
-					log.Println("error messages channel full :(")
-				}
+// twitchMessage converts PRIVMSG, USERNOTICE and moderation lines to log messages
+// > @badges=staff/1,broadcaster/1,turbo/1;color=#008000;display-name=ronni;emotes=;mod=0;msg-id=resub;msg-param-months=6;
+// msg-param-sub-plan=Prime;msg-param-sub-plan-name=Prime;room-id=1337;subscriber=1;system-msg=ronni\shas\ssubscribed\sfor\s6\smonths!;
+// login=ronni;turbo=1;user-id=1337;user-type=staff :tmi.twitch.tv USERNOTICE #dallas :Great stream -- keep it up!
+func twitchMessage(im *IRCMessage) *Message {
+	switch im.Command {
+	case "PRIVMSG":
+		data := strings.TrimSpace(im.Trailing())
+		data = strings.Replace(data, "\x01ACTION", "/me", -1)
+		data = strings.Replace(data, "\x01", "", -1)
+		return &Message{
+			Type:    "MSG",
+			Channel: im.Channel(),
+			Nick:    im.Nick(),
+			Data:    data,
+			Time:    im.Time(),
+			Tags:    im.Tags,
+		}
+	case "USERNOTICE":
+		if _, ok := twitchSubTypes[im.Tags["msg-id"]]; ok {
+			data := im.Tags["system-msg"]
+			if len(im.Params) > 1 {
+				data += " [SubMessage]: " + im.Trailing()
+			}
+			return &Message{
+				Type:    "MSG",
+				Channel: im.Channel(),
+				Nick:    "twitchnotify",
+				Data:    data,
+				Time:    im.Time(),
+				Tags:    im.Tags,
 			}
-			c.lastMessageMu.Lock()
-			c.lastMessage = time.Now()
-			c.lastMessageMu.Unlock()
 		}
-	}()
+		// > @login=ronni;msg-id=raid;msg-param-displayName=ronni;msg-param-viewerCount=42;
+		// system-msg=42\sraiders\sfrom\sronni\shave\sjoined!;tmi-sent-ts=1507246572675 :tmi.twitch.tv USERNOTICE #dallas
+		data := strings.TrimSpace(im.Tags["system-msg"])
+		if len(im.Params) > 1 {
+			data = strings.TrimSpace(data + " [Message]: " + im.Trailing())
+		}
+		return &Message{
+			Type:    "USERNOTICE",
+			Channel: im.Channel(),
+			Nick:    im.Tags["login"],
+			Data:    data,
+			Time:    im.Time(),
+			Tags:    im.Tags,
+		}
+	case "CLEARCHAT":
+		// > @ban-duration=600;room-id=1337;target-user-id=1338;tmi-sent-ts=1507246572675 :tmi.twitch.tv CLEARCHAT #dallas :ronni
+		m := &Message{
+			Type:    "CLEARCHAT",
+			Channel: im.Channel(),
+			Time:    im.Time(),
+			Tags:    im.Tags,
+		}
+		if len(im.Params) > 1 {
+			m.Type = "BAN"
+			m.Data = im.Trailing()
+			if _, ok := im.Tags["ban-duration"]; ok {
+				m.Type = "TIMEOUT"
+			}
+		}
+		return m
+	case "CLEARMSG":
+		// > @login=ronni;room-id=;target-msg-id=abc-123-def;tmi-sent-ts=1507246572675 :tmi.twitch.tv CLEARMSG #dallas :HeyGuys
+		return &Message{
+			Type:    "CLEARMSG",
+			Channel: im.Channel(),
+			Nick:    im.Tags["login"],
+			Data:    im.Trailing(),
+			Time:    im.Time(),
+			Tags:    im.Tags,
+		}
+	}
+	return nil
 }
 
 // Channels ...
 func (c *Twitch) Channels() []string {
-	return c.channels
+	c.ChLock.RLock()
+	defer c.ChLock.RUnlock()
+	channels := make([]string, len(c.channels))
+	copy(channels, c.channels)
+	return channels
 }
 
 // Messages channel accessor
@@ -213,48 +288,82 @@ func (c *Twitch) Message(ch, payload string) error {
 }
 
 func (c *Twitch) send(m string) error {
+	c.connLock.Lock()
+	conn := c.conn
+	c.connLock.Unlock()
+	if conn == nil || c.State() != StateConnected {
+		return errors.New("not connected")
+	}
+	return c.write(conn, m)
+}
+
+func (c *Twitch) write(conn *websocket.Conn, m string) error {
 	c.sendLock.Lock()
-	err := c.conn.SetWriteDeadline(time.Now().Add(SocketWriteTimeout))
-	c.sendLock.Unlock()
-	if err != nil {
+	defer c.sendLock.Unlock()
+	if err := conn.SetWriteDeadline(time.Now().Add(SocketWriteTimeout)); err != nil {
 		return fmt.Errorf("error setting SetWriteDeadline %s", err)
 	}
-	c.sendLock.Lock()
-	err = c.conn.WriteMessage(websocket.TextMessage, []byte(m+"\r\n"))
-	c.sendLock.Unlock()
-	if err != nil {
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(m+"\r\n")); err != nil {
 		return fmt.Errorf("error sending message %s", err)
 	}
 	time.Sleep(SocketWriteDebounce)
 	return nil
 }
 
-// Join channel
+// Join channel, channels are joined once connected if the client is offline
 func (c *Twitch) Join(ch string) error {
 	ch = strings.ToLower(ch)
-	err := c.send("JOIN #" + ch)
-	if err != nil {
-		c.reconnect()
-		return err
-	}
 	c.ChLock.Lock()
-	defer c.ChLock.Unlock()
 	if inSlice(c.channels, ch) {
+		c.ChLock.Unlock()
 		return errors.New("already in channel")
 	}
 	c.channels = append(c.channels, ch)
+	c.ChLock.Unlock()
+
+	if c.State() != StateConnected {
+		return nil
+	}
+	if err := c.sendJoin(ch); err != nil {
+		log.Printf("error joining %s: %s", ch, err)
+		c.reconnect()
+	}
 	return nil
 }
 
+// sendJoin JOIN ch on the current connection
+func (c *Twitch) sendJoin(ch string) error {
+	c.connLock.Lock()
+	conn := c.conn
+	c.connLock.Unlock()
+	if conn == nil || c.State() != StateConnected {
+		return errors.New("not connected")
+	}
+	return c.join(conn, ch)
+}
+
+// join waits for the shared JOIN rate limit before joining ch
+func (c *Twitch) join(conn *websocket.Conn, ch string) error {
+	if err := TwitchJoinLimiter().Wait(c.ctx); err != nil {
+		return err
+	}
+	return c.write(conn, "JOIN #"+ch)
+}
+
 // Leave channel
 func (c *Twitch) Leave(ch string) error {
 	ch = strings.ToLower(ch)
-	err := c.send("PART #" + ch)
-	if err != nil {
+	if err := c.removeChannel(ch); err != nil {
+		return err
+	}
+	if c.State() != StateConnected {
+		return nil
+	}
+	if err := c.send("PART #" + ch); err != nil {
 		log.Printf("error leaving channel: %s", err)
 		c.reconnect()
 	}
-	return c.removeChannel(ch)
+	return nil
 }
 
 func (c *Twitch) removeChannel(ch string) error {
@@ -269,47 +378,16 @@ func (c *Twitch) removeChannel(ch string) error {
 	return errors.New("not in channel")
 }
 
-func (c *Twitch) rejoinHandler() {
-	const interval = 2 * time.Hour
-	ticker := time.NewTicker(interval)
-	timeout := time.NewTicker(3 * time.Minute)
-	for {
-		select {
-		case <-timeout.C:
-			c.lastMessageMu.RLock()
-			if time.Now().Sub(c.lastMessage).Minutes() > 2 {
-				c.lastMessageMu.RUnlock()
-				log.Println("twitch timeout triggered")
-				c.reconnect()
-				continue
-			}
-			c.lastMessageMu.RUnlock()
-		case <-c.quit:
-			ticker.Stop()
-			return
-		case <-ticker.C:
-			c.ChLock.RLock()
-			for _, ch := range c.channels {
-				ch = strings.ToLower(ch)
-				if err := c.send("JOIN #" + ch); err != nil {
-					log.Println(err)
-					continue
-				}
-			}
-			c.ChLock.RUnlock()
-		}
-	}
+// Reconnect drop the connection and reconnect
+func (c *Twitch) Reconnect() {
+	c.reconnect()
 }
 
 // Stop stops the chats
-func (c *Twitch) Stop(wg *sync.WaitGroup) {
-	close(c.quit)
-	c.connLock.Lock()
-	if c.conn != nil {
-		c.conn.Close()
-	}
-	c.connLock.Unlock()
-	wg.Done()
+func (c *Twitch) Stop() {
+	c.cancel()
+	c.reconnect()
+	c.stop()
 }
 
 func inSlice(s []string, v string) bool {
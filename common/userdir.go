@@ -0,0 +1,440 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// user directory settings
+const (
+	// UserDirName directory in the logs root holding the user directory
+	UserDirName = "users"
+	// UserDirShards nicks are spread over this many files so a lookup reads
+	// one small file
+	UserDirShards = 256
+
+	userDirVersion = 1
+)
+
+// errors
+var (
+	ErrNickNotFound = errors.New("nick not found")
+)
+
+// UserChannel activity of a nick in a channel
+type UserChannel struct {
+	// Channel name without the " chatlog" suffix
+	Channel string
+	// Months activity per month, oldest first
+	Months []*NickStats
+}
+
+// First first line in the channel
+func (c *UserChannel) First() time.Time {
+	return c.Months[0].First
+}
+
+// Last latest line in the channel
+func (c *UserChannel) Last() time.Time {
+	last := c.Months[0].Last
+	for _, m := range c.Months[1:] {
+		if m.Last.After(last) {
+			last = m.Last
+		}
+	}
+	return last
+}
+
+// Lines total lines in the channel
+func (c *UserChannel) Lines() int {
+	var n int
+	for _, m := range c.Months {
+		n += m.Lines
+	}
+	return n
+}
+
+// Bytes total bytes in the channel
+func (c *UserChannel) Bytes() int {
+	var n int
+	for _, m := range c.Months {
+		n += m.Bytes
+	}
+	return n
+}
+
+// Days total days active in the channel
+func (c *UserChannel) Days() int {
+	var n int
+	for _, m := range c.Months {
+		n += len(m.ActiveDays())
+	}
+	return n
+}
+
+// set replaces the stats of the month s belongs to
+func (c *UserChannel) set(s *NickStats) {
+	i := sort.Search(len(c.Months), func(i int) bool {
+		return !monthStart(c.Months[i].First).Before(monthStart(s.First))
+	})
+	if i < len(c.Months) && monthStart(c.Months[i].First).Equal(monthStart(s.First)) {
+		c.Months[i] = s
+		return
+	}
+	c.Months = append(c.Months, nil)
+	copy(c.Months[i+1:], c.Months[i:])
+	c.Months[i] = s
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// UserEntry channels a nick wrote in
+type UserEntry struct {
+	// Nick case of the nick's latest line
+	Nick string
+	// Channels sorted by name
+	Channels []*UserChannel
+	last     time.Time
+}
+
+// Lines total lines in every channel
+func (e *UserEntry) Lines() int {
+	var n int
+	for _, c := range e.Channels {
+		n += c.Lines()
+	}
+	return n
+}
+
+// Bytes total bytes in every channel
+func (e *UserEntry) Bytes() int {
+	var n int
+	for _, c := range e.Channels {
+		n += c.Bytes()
+	}
+	return n
+}
+
+// Channel activity in a channel, nil if the nick never wrote in it
+func (e *UserEntry) Channel(name string) *UserChannel {
+	i := sort.Search(len(e.Channels), func(i int) bool { return e.Channels[i].Channel >= name })
+	if i < len(e.Channels) && e.Channels[i].Channel == name {
+		return e.Channels[i]
+	}
+	return nil
+}
+
+// set replaces the nick's stats of a month in channel
+func (e *UserEntry) set(channel string, s *NickStats) {
+	c := e.Channel(channel)
+	if c == nil {
+		c = &UserChannel{Channel: channel}
+		i := sort.Search(len(e.Channels), func(i int) bool { return e.Channels[i].Channel >= channel })
+		e.Channels = append(e.Channels, nil)
+		copy(e.Channels[i+1:], e.Channels[i:])
+		e.Channels[i] = c
+	}
+	c.set(s)
+	if !s.Last.Before(e.last) {
+		e.last = s.Last
+		e.Nick = s.Nick
+	}
+}
+
+// UserDirectory channels every nick wrote in keyed by lower case nick
+type UserDirectory map[string]*UserEntry
+
+// Get entry of a nick, case insensitive
+func (d UserDirectory) Get(nick string) (*UserEntry, bool) {
+	e, ok := d[strings.ToLower(nick)]
+	return e, ok
+}
+
+// AddIndex sets the stats of every nick in a channel's month index,
+// replacing the ones of that month added before
+func (d UserDirectory) AddIndex(channel string, idx NickIndex) {
+	for key, s := range idx {
+		e, ok := d[key]
+		if !ok {
+			e = &UserEntry{}
+			d[key] = e
+		}
+		e.set(channel, s)
+	}
+}
+
+// UserDirPath shard of the directory holding nick
+func UserDirPath(root, nick string) string {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(nick)))
+	return userDirShardPath(root, int(h.Sum32()%UserDirShards))
+}
+
+func userDirShardPath(root string, shard int) string {
+	return filepath.Join(root, UserDirName, fmt.Sprintf("%02x.gz", shard))
+}
+
+// shards splits the directory by the shard file of each nick
+func (d UserDirectory) shards(root string) map[string]UserDirectory {
+	shards := map[string]UserDirectory{}
+	for key, e := range d {
+		path := UserDirPath(root, key)
+		s, ok := shards[path]
+		if !ok {
+			s = UserDirectory{}
+			shards[path] = s
+		}
+		s[key] = e
+	}
+	return shards
+}
+
+// WriteTo replaces the directory in root, shards without nicks are written
+// empty so nicks that were removed don't linger
+func (d UserDirectory) WriteTo(root string) error {
+	shards := d.shards(root)
+	for i := 0; i < UserDirShards; i++ {
+		path := userDirShardPath(root, i)
+		if err := shards[path].writeShard(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeInto updates the months in d in the directory in root, months and
+// nicks that aren't in d are kept
+func (d UserDirectory) MergeInto(root string) error {
+	for path, shard := range d.shards(root) {
+		cur, err := readUserDirShard(path)
+		if os.IsNotExist(err) {
+			cur = UserDirectory{}
+		} else if err != nil {
+			log.Printf("error reading user directory, replacing %s", err)
+			cur = UserDirectory{}
+		}
+		for key, e := range shard {
+			ce, ok := cur[key]
+			if !ok {
+				ce = &UserEntry{}
+				cur[key] = ce
+			}
+			for _, c := range e.Channels {
+				for _, s := range c.Months {
+					ce.set(c.Channel, s)
+				}
+			}
+		}
+		if err := cur.writeShard(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d UserDirectory) writeShard(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(d))
+	for k := range d {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) {
+		buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+	}
+	putString := func(s string) {
+		putUvarint(uint64(len(s)))
+		buf.WriteString(s)
+	}
+	putUvarint(userDirVersion)
+	putUvarint(uint64(len(keys)))
+	for _, k := range keys {
+		e := d[k]
+		putString(e.Nick)
+		putUvarint(uint64(len(e.Channels)))
+		for _, c := range e.Channels {
+			putString(c.Channel)
+			putUvarint(uint64(len(c.Months)))
+			for _, s := range c.Months {
+				putUvarint(uint64(s.Lines))
+				putUvarint(uint64(s.Bytes))
+				putUvarint(uint64(s.First.Unix()))
+				putUvarint(uint64(s.Last.Unix() - s.First.Unix()))
+				putUvarint(uint64(s.Days))
+			}
+		}
+	}
+	_, err := WriteCompressedFile(path, buf.Bytes())
+	return err
+}
+
+func readUserDirShard(path string) (UserDirectory, error) {
+	data, err := ReadCompressedFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(bytes.NewReader(data))
+	readUvarint := func() uint64 {
+		if err != nil {
+			return 0
+		}
+		var v uint64
+		v, err = binary.ReadUvarint(r)
+		return v
+	}
+	readString := func() string {
+		size := readUvarint()
+		if size > maxNickIndexNick {
+			err = errors.New("string too long")
+		}
+		if err != nil {
+			return ""
+		}
+		b := make([]byte, size)
+		_, err = io.ReadFull(r, b)
+		return string(b)
+	}
+	// counts can't exceed the bytes left, larger ones mean the file is corrupt
+	readCount := func() int {
+		n := readUvarint()
+		if n > uint64(len(data)) {
+			err = errors.New("invalid count")
+			return 0
+		}
+		return int(n)
+	}
+	corrupt := func() error {
+		return fmt.Errorf("corrupt user directory %s %s", path, err)
+	}
+
+	if v := readUvarint(); err == nil && v != userDirVersion {
+		return nil, errors.New("unsupported user directory version")
+	}
+	count := readCount()
+	if err != nil {
+		return nil, corrupt()
+	}
+	d := make(UserDirectory, count)
+	for i := 0; i < count; i++ {
+		e := &UserEntry{Nick: readString()}
+		channels := readCount()
+		for j := 0; j < channels && err == nil; j++ {
+			c := &UserChannel{Channel: readString()}
+			months := readCount()
+			for k := 0; k < months && err == nil; k++ {
+				s := &NickStats{
+					Nick:  e.Nick,
+					Lines: int(readUvarint()),
+					Bytes: int(readUvarint()),
+				}
+				first := int64(readUvarint())
+				last := first + int64(readUvarint())
+				s.First = time.Unix(first, 0).UTC()
+				s.Last = time.Unix(last, 0).UTC()
+				s.Days = uint32(readUvarint())
+				c.Months = append(c.Months, s)
+				if s.Last.After(e.last) {
+					e.last = s.Last
+				}
+			}
+			if len(c.Months) > 0 {
+				e.Channels = append(e.Channels, c)
+			}
+		}
+		if err != nil {
+			return nil, corrupt()
+		}
+		d[strings.ToLower(e.Nick)] = e
+	}
+	return d, nil
+}
+
+// LookupUser reads the directory entry of nick from root
+func LookupUser(root, nick string) (*UserEntry, error) {
+	d, err := readUserDirShard(UserDirPath(root, nick))
+	if os.IsNotExist(err) {
+		return nil, ErrNickNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	e, ok := d.Get(nick)
+	if !ok {
+		return nil, ErrNickNotFound
+	}
+	return e, nil
+}
+
+// channelIndexes adds the nick indexes of a channel's months to d, months
+// without an index are indexed from their logs
+func (d UserDirectory) channelIndexes(root, channel string, months []string) {
+	name := strings.TrimSuffix(channel, " chatlog")
+	for _, m := range months {
+		dir := filepath.Join(root, channel, m)
+		idx, err := ReadNickIndex(NickIndexPath(dir))
+		if os.IsNotExist(err) {
+			idx, err = BuildNickIndex(dir)
+		}
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("error reading nick index of %s %s", dir, err)
+			}
+			continue
+		}
+		d.AddIndex(name, idx)
+	}
+}
+
+// BuildUserDirectory indexes every month of every channel in root
+func BuildUserDirectory(root string) (UserDirectory, error) {
+	channels, err := Channels(Store(), root)
+	if err != nil {
+		return nil, err
+	}
+	d := UserDirectory{}
+	for _, ch := range channels {
+		months, err := Months(Store(), root, ch)
+		if err != nil {
+			log.Printf("error listing months of %s %s", ch, err)
+			continue
+		}
+		d.channelIndexes(root, ch, months)
+	}
+	return d, nil
+}
+
+// UpdateUserDirectory merges the current and previous month of every channel
+// into the directory in root, older months don't change once they're over
+func UpdateUserDirectory(root string, now time.Time) error {
+	channels, err := Channels(Store(), root)
+	if err != nil {
+		return err
+	}
+	months := []string{
+		monthStart(now).AddDate(0, -1, 0).Format("January 2006"),
+		now.Format("January 2006"),
+	}
+	d := UserDirectory{}
+	for _, ch := range channels {
+		d.channelIndexes(root, ch, months)
+	}
+	return d.MergeInto(root)
+}
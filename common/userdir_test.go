@@ -0,0 +1,91 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUserDirectory(t *testing.T) {
+	root := t.TempDir()
+	jan := time.Date(2017, 1, 10, 8, 0, 0, 0, time.UTC)
+	feb := time.Date(2017, 2, 3, 8, 0, 0, 0, time.UTC)
+	for _, l := range []struct {
+		channel string
+		day     time.Time
+	}{
+		{"Foo chatlog", jan},
+		{"Foo chatlog", feb},
+		{"Bar chatlog", feb},
+	} {
+		dir := filepath.Join(root, l.channel, l.day.Format("January 2006"))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, l.day.Format("2006-01-02")+".txt"), []byte(testDayLog(l.day, 46)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d, err := BuildUserDirectory(root)
+	if err != nil {
+		t.Fatalf("error building directory %s", err)
+	}
+	if err := d.WriteTo(root); err != nil {
+		t.Fatalf("error writing directory %s", err)
+	}
+
+	e, err := LookupUser(root, "NICK3")
+	if err != nil {
+		t.Fatalf("error looking up nick %s", err)
+	}
+	if len(e.Channels) != 2 || e.Channels[0].Channel != "Bar" || e.Channels[1].Channel != "Foo" {
+		t.Fatalf("invalid channels, got: %+v", e.Channels)
+	}
+	foo := e.Channel("Foo")
+	if len(foo.Months) != 2 || foo.Lines() != 4 || foo.Days() != 2 {
+		t.Errorf("invalid Foo stats, got: %d months %d lines %d days", len(foo.Months), foo.Lines(), foo.Days())
+	}
+	if !foo.First().Equal(jan.Add(3*7*time.Second)) || foo.Last().Before(feb) {
+		t.Errorf("invalid first/last seen, got: %s %s", foo.First(), foo.Last())
+	}
+	if e.Lines() != 6 {
+		t.Errorf("expected 6 lines, got: %d", e.Lines())
+	}
+	if _, err := LookupUser(root, "nobody"); err != ErrNickNotFound {
+		t.Errorf("expected nick not found, got: %v", err)
+	}
+
+	// merging replaces the months it has and keeps the rest
+	idx := NickIndex{}
+	idx.Add("Nick3", feb.AddDate(0, 0, 1), 10)
+	u := UserDirectory{}
+	u.AddIndex("Foo", idx)
+	if err := u.MergeInto(root); err != nil {
+		t.Fatalf("error merging directory %s", err)
+	}
+	e, err = LookupUser(root, "nick3")
+	if err != nil {
+		t.Fatalf("error looking up nick %s", err)
+	}
+	foo = e.Channel("Foo")
+	if e.Nick != "Nick3" || len(foo.Months) != 2 || foo.Lines() != 3 || e.Channel("Bar").Lines() != 2 {
+		t.Errorf("invalid merged entry, got: %s %d months %d lines", e.Nick, len(foo.Months), foo.Lines())
+	}
+}
+
+func TestUserDirectoryCorrupt(t *testing.T) {
+	root := t.TempDir()
+	path := UserDirPath(root, "nick")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WriteCompressedFile(path, []byte{userDirVersion, 1, 4, 'n', 'i', 'c', 'k', 200}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LookupUser(root, "nick"); err == nil || err == ErrNickNotFound {
+		t.Errorf("expected corrupt directory error, got: %v", err)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+// Connection chat connection summary
+type Connection struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Channels int    `json:"channels"`
+}
+
+// connectionLister sources with more than one connection
+type connectionLister interface {
+	Connections() []Connection
+}
+
+// connection sources backed by a single connection
+type connection interface {
+	Name() string
+	State() common.ConnState
+}
+
+type reconnecter interface {
+	Reconnect()
+}
+
+type adminSource struct {
+	name   string
+	source common.ChatSource
+	logs   *ChatLogs
+}
+
+// Admin authenticated http api for managing the running logger
+type Admin struct {
+	sourceLock sync.RWMutex
+	sources    []*adminSource
+}
+
+// NewAdmin new admin api accepting requests bearing the configured adminToken
+func NewAdmin() *Admin {
+	return &Admin{}
+}
+
+// Add makes a source available to the api, sources of the same kind are
+// numbered after the first one
+func (a *Admin) Add(kind string, source common.ChatSource, logs *ChatLogs) {
+	a.sourceLock.Lock()
+	defer a.sourceLock.Unlock()
+	name := kind
+	for i := 2; a.source(name) != nil; i++ {
+		name = fmt.Sprintf("%s-%d", kind, i)
+	}
+	a.sources = append(a.sources, &adminSource{name, source, logs})
+}
+
+func (a *Admin) source(name string) *adminSource {
+	for _, s := range a.sources {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// ServeHTTP implements http.Handler
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := common.GetConfig().AdminToken
+	auth := []byte(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if token == "" || subtle.ConstantTimeCompare(auth, []byte(token)) != 1 {
+		serveAdminError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case path == "channels" && r.Method == "GET":
+		a.channels(w, r)
+	case strings.HasPrefix(path, "channels/") && (r.Method == "PUT" || r.Method == "POST"):
+		a.join(w, r, strings.TrimPrefix(path, "channels/"))
+	case strings.HasPrefix(path, "channels/") && r.Method == "DELETE":
+		a.leave(w, r, strings.TrimPrefix(path, "channels/"))
+	case path == "connections" && r.Method == "GET":
+		a.connections(w, r)
+	case path == "reconnect" && r.Method == "POST":
+		a.reconnect(w, r)
+	case path == "flush" && r.Method == "POST":
+		a.flush(w, r)
+	default:
+		serveAdminError(w, "not found", http.StatusNotFound)
+	}
+}
+
+// selected returns the sources picked by the source query param, all of them if it's empty
+func (a *Admin) selected(w http.ResponseWriter, r *http.Request) ([]*adminSource, bool) {
+	a.sourceLock.RLock()
+	defer a.sourceLock.RUnlock()
+	name := r.URL.Query().Get("source")
+	if name == "" {
+		sources := make([]*adminSource, len(a.sources))
+		copy(sources, a.sources)
+		return sources, true
+	}
+	s := a.source(name)
+	if s == nil {
+		serveAdminError(w, "unknown source "+name, http.StatusNotFound)
+		return nil, false
+	}
+	return []*adminSource{s}, true
+}
+
+// single returns the source picked by the source query param, it may only
+// be left out when there's a single source
+func (a *Admin) single(w http.ResponseWriter, r *http.Request) (*adminSource, bool) {
+	sources, ok := a.selected(w, r)
+	if !ok {
+		return nil, false
+	}
+	if len(sources) != 1 {
+		serveAdminError(w, "source required", http.StatusBadRequest)
+		return nil, false
+	}
+	return sources[0], true
+}
+
+func (a *Admin) channels(w http.ResponseWriter, r *http.Request) {
+	sources, ok := a.selected(w, r)
+	if !ok {
+		return
+	}
+	channels := map[string][]string{}
+	for _, s := range sources {
+		channels[s.name] = s.source.Channels()
+	}
+	serveAdminJSON(w, channels)
+}
+
+func (a *Admin) join(w http.ResponseWriter, r *http.Request, ch string) {
+	s, ok := a.single(w, r)
+	if !ok {
+		return
+	}
+	if err := s.source.Join(ch); err != nil {
+		serveAdminError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("admin joined %s on %s", ch, s.name)
+	serveAdminJSON(w, map[string]string{"joined": ch})
+}
+
+func (a *Admin) leave(w http.ResponseWriter, r *http.Request, ch string) {
+	s, ok := a.single(w, r)
+	if !ok {
+		return
+	}
+	if err := s.source.Leave(ch); err != nil {
+		serveAdminError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("admin left %s on %s", ch, s.name)
+	serveAdminJSON(w, map[string]string{"left": ch})
+}
+
+func (a *Admin) connections(w http.ResponseWriter, r *http.Request) {
+	sources, ok := a.selected(w, r)
+	if !ok {
+		return
+	}
+	conns := map[string][]Connection{}
+	for _, s := range sources {
+		switch c := s.source.(type) {
+		case connectionLister:
+			conns[s.name] = c.Connections()
+		case connection:
+			conns[s.name] = []Connection{{
+				Name:     c.Name(),
+				State:    c.State().String(),
+				Channels: len(s.source.Channels()),
+			}}
+		}
+	}
+	serveAdminJSON(w, conns)
+}
+
+func (a *Admin) reconnect(w http.ResponseWriter, r *http.Request) {
+	sources, ok := a.selected(w, r)
+	if !ok {
+		return
+	}
+	var names []string
+	for _, s := range sources {
+		if c, ok := s.source.(reconnecter); ok {
+			log.Printf("admin reconnecting %s", s.name)
+			c.Reconnect()
+			names = append(names, s.name)
+		}
+	}
+	serveAdminJSON(w, map[string][]string{"reconnected": names})
+}
+
+func (a *Admin) flush(w http.ResponseWriter, r *http.Request) {
+	sources, ok := a.selected(w, r)
+	if !ok {
+		return
+	}
+	flushed := map[string]int{}
+	for _, s := range sources {
+		flushed[s.name] = s.logs.Flush()
+	}
+	serveAdminJSON(w, flushed)
+}
+
+func serveAdminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func serveAdminError(w http.ResponseWriter, msg string, code int) {
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(struct {
+		Message string `json:"message"`
+	}{msg})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+// setTestConfig loads a config from toml for the rest of the test
+func setTestConfig(t *testing.T, conf string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "overrustlelogs.toml")
+	if err := ioutil.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := common.ReloadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var (
+	errAlreadyJoined = errors.New("already joined")
+	errNotJoined     = errors.New("not joined")
+)
+
+type testSource struct {
+	sync.Mutex
+	channels   []string
+	reconnects int
+}
+
+func (s *testSource) Run()  {}
+func (s *testSource) Stop() {}
+
+func (s *testSource) Join(ch string) error {
+	s.Lock()
+	defer s.Unlock()
+	if inSlice(s.channels, ch) {
+		return errAlreadyJoined
+	}
+	s.channels = append(s.channels, ch)
+	return nil
+}
+
+func (s *testSource) Leave(ch string) error {
+	s.Lock()
+	defer s.Unlock()
+	for i, c := range s.channels {
+		if c == ch {
+			s.channels = append(s.channels[:i], s.channels[i+1:]...)
+			return nil
+		}
+	}
+	return errNotJoined
+}
+
+func (s *testSource) Channels() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string(nil), s.channels...)
+}
+
+func (s *testSource) Messages() <-chan *common.Message { return nil }
+
+func (s *testSource) Reconnect() {
+	s.Lock()
+	s.reconnects++
+	s.Unlock()
+}
+
+func adminRequest(a *Admin, method, url, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+	return w
+}
+
+func TestAdminAuth(t *testing.T) {
+	a := NewAdmin()
+	a.Add("irc", &testSource{}, nil)
+
+	setTestConfig(t, `adminToken = ""`)
+	if w := adminRequest(a, "GET", "/channels", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized without a configured token, got: %d", w.Code)
+	}
+
+	setTestConfig(t, `adminToken = "secret"`)
+	for _, token := range []string{"", "wrong", "secretsecret"} {
+		if w := adminRequest(a, "GET", "/channels", token); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected unauthorized with token %q, got: %d", token, w.Code)
+		}
+	}
+	if w := adminRequest(a, "GET", "/channels", "secret"); w.Code != http.StatusOK {
+		t.Errorf("expected ok with the configured token, got: %d", w.Code)
+	}
+}
+
+func TestAdminJoinLeave(t *testing.T) {
+	setTestConfig(t, `adminToken = "secret"`)
+	irc := &testSource{}
+	a := NewAdmin()
+	a.Add("irc", irc, nil)
+
+	if w := adminRequest(a, "PUT", "/channels/destiny", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("join failed, got: %d %s", w.Code, w.Body)
+	}
+	if w := adminRequest(a, "PUT", "/channels/destiny", "secret"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected bad request joining twice, got: %d", w.Code)
+	}
+
+	w := adminRequest(a, "GET", "/channels", "secret")
+	var channels map[string][]string
+	if err := json.NewDecoder(w.Body).Decode(&channels); err != nil {
+		t.Fatal(err)
+	}
+	if len(channels["irc"]) != 1 || channels["irc"][0] != "destiny" {
+		t.Errorf("invalid channels, got: %v", channels)
+	}
+
+	if w := adminRequest(a, "DELETE", "/channels/destiny", "secret"); w.Code != http.StatusOK {
+		t.Errorf("leave failed, got: %d %s", w.Code, w.Body)
+	}
+	if w := adminRequest(a, "DELETE", "/channels/destiny", "secret"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected bad request leaving twice, got: %d", w.Code)
+	}
+	if len(irc.Channels()) != 0 {
+		t.Errorf("expected no channels, got: %v", irc.Channels())
+	}
+}
+
+func TestAdminSources(t *testing.T) {
+	setTestConfig(t, `adminToken = "secret"`)
+	a := NewAdmin()
+	first, second := &testSource{}, &testSource{}
+	a.Add("irc", first, nil)
+	a.Add("irc", second, nil)
+
+	if w := adminRequest(a, "PUT", "/channels/destiny", "secret"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected bad request joining without a source, got: %d", w.Code)
+	}
+	if w := adminRequest(a, "PUT", "/channels/destiny?source=twitch", "secret"); w.Code != http.StatusNotFound {
+		t.Errorf("expected not found for an unknown source, got: %d", w.Code)
+	}
+	if w := adminRequest(a, "PUT", "/channels/destiny?source=irc-2", "secret"); w.Code != http.StatusOK {
+		t.Errorf("join failed, got: %d %s", w.Code, w.Body)
+	}
+	if len(first.Channels()) != 0 || len(second.Channels()) != 1 {
+		t.Errorf("joined the wrong source, got: %v %v", first.Channels(), second.Channels())
+	}
+	if w := adminRequest(a, "GET", "/nope", "secret"); w.Code != http.StatusNotFound {
+		t.Errorf("expected not found, got: %d", w.Code)
+	}
+}
+
+func TestAdminReconnect(t *testing.T) {
+	setTestConfig(t, `adminToken = "secret"`)
+	a := NewAdmin()
+	first, second := &testSource{}, &testSource{}
+	a.Add("irc", first, nil)
+	a.Add("irc", second, nil)
+
+	if w := adminRequest(a, "GET", "/reconnect", "secret"); w.Code != http.StatusNotFound {
+		t.Errorf("expected not found for GET, got: %d", w.Code)
+	}
+	if w := adminRequest(a, "POST", "/reconnect?source=irc", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("reconnect failed, got: %d %s", w.Code, w.Body)
+	}
+	if first.reconnects != 1 || second.reconnects != 0 {
+		t.Errorf("reconnected the wrong source, got: %d %d", first.reconnects, second.reconnects)
+	}
+
+	w := adminRequest(a, "POST", "/reconnect", "secret")
+	var res map[string][]string
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res["reconnected"]) != 2 || first.reconnects != 2 || second.reconnects != 1 {
+		t.Errorf("expected every source to reconnect, got: %v %d %d", res, first.reconnects, second.reconnects)
+	}
+}
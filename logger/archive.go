@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+// ArchiveInterval how often closed months are moved to the s3 archive
+const ArchiveInterval = 24 * time.Hour
+
+// archiveLoop moves closed months to the configured s3 archive, the settings
+// are read on every run so reloading the config enables it
+func archiveLoop(quit <-chan struct{}) {
+	t := time.NewTicker(ArchiveInterval)
+	defer t.Stop()
+	for {
+		if conf := common.GetConfig().S3; conf.Bucket != "" {
+			archive := common.NewS3Store(conf, LogsPath)
+			// late appends to archived months continue the archived copies
+			common.SetStore(&common.TieredStore{Local: common.FileStore{}, Archive: archive})
+			if err := common.ArchiveMonths(common.FileStore{}, archive, LogsPath, time.Now().UTC()); err != nil {
+				log.Printf("error archiving logs %s", err)
+			}
+		}
+		select {
+		case <-quit:
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"encoding/json"
+	"expvar"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -13,66 +16,188 @@ import (
 
 // var empty struct{}
 
+// DefaultFsyncInterval how often written logs are synced to disk if fsyncInterval isn't set
+const DefaultFsyncInterval = 5 * time.Second
+
+// LogWriteErrors per channel write and sync errors, served on /debug/vars
+var LogWriteErrors = expvar.NewMap("log_write_errors")
+
 // ChatLog handles single log file
 type ChatLog struct {
 	sync.Mutex
-	f        *os.File
+	channel  string
+	path     string
+	f        io.WriteCloser
+	sidecar  io.WriteCloser
 	nicks    common.NickList
+	search   *common.SearchIndexWriter
 	modified time.Time
+	dirty    bool
+	closed   bool
 }
 
 // NewChatLog instantiates chat logs...
 func NewChatLog(path string) (*ChatLog, error) {
-	dir := filepath.Dir(path)
-	if _, err := os.Stat(dir); err != nil {
-		err := os.MkdirAll(dir, 0755)
-		if err != nil {
-			return nil, err
+	for _, p := range []string{path, sidecarPath(path)} {
+		if err := recoverLog(p); err != nil {
+			log.Printf("error recovering %s %s", p, err)
 		}
 	}
 
 	if _, err := common.UncompressFile(path); !os.IsNotExist(err) && err != nil {
 		log.Printf("error reading log %s %s", path, err)
 	}
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	f, err := common.Store().Append(path)
 	if err != nil {
 		return nil, err
 	}
 
+	if _, err := common.UncompressFile(sidecarPath(path)); !os.IsNotExist(err) && err != nil {
+		log.Printf("error reading sidecar %s %s", path, err)
+	}
+	sidecar, err := common.Store().Append(sidecarPath(path))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
 	nicks := common.NickList{}
 	common.ReadNickList(nicks, nickPath(path))
 
+	// search is best effort, the tool can rebuild the index from the logs
+	search, err := common.NewSearchIndexWriter(path)
+	if err != nil {
+		log.Printf("error opening search index for %s %s", path, err)
+	}
+
 	return &ChatLog{
+		channel:  filepath.Base(filepath.Dir(filepath.Dir(path))),
+		path:     path,
 		f:        f,
+		sidecar:  sidecar,
 		nicks:    nicks,
+		search:   search,
 		modified: time.Now(),
 	}, nil
 }
 
-// WriteNicks persist nick list
+// WriteNicks persist nick list, the month's nick index and the lines
+// written since the last call to the search index
 func (l *ChatLog) WriteNicks() {
 	l.Lock()
-	if err := l.nicks.WriteTo(nickPath(l.f.Name())); err != nil {
-		log.Printf("error writing nicks for %s %s", l.f.Name(), err)
+	if l.closed {
+		l.Unlock()
+		return
 	}
+	l.writeNicks()
 	l.Unlock()
+	nickIndexes.Write(filepath.Dir(l.path))
 }
 
-// Close release file handle
-func (l *ChatLog) Close() {
+func (l *ChatLog) writeNicks() {
+	if err := l.nicks.WriteTo(nickPath(l.path)); err != nil {
+		log.Printf("error writing nicks for %s %s", l.path, err)
+	}
+	if l.search != nil {
+		if err := l.search.Flush(); err != nil {
+			log.Printf("error writing search index for %s %s", l.path, err)
+		}
+	}
+}
+
+// Flush persist nick list and sync log files to disk
+func (l *ChatLog) Flush() {
 	l.WriteNicks()
 	l.Lock()
+	if !l.closed {
+		l.sync()
+	}
+	l.Unlock()
+}
+
+// Sync sync log files to disk if they were written to since the last sync
+func (l *ChatLog) Sync() {
+	l.Lock()
+	if l.dirty {
+		l.sync()
+	}
+	l.Unlock()
+}
+
+func (l *ChatLog) sync() {
+	l.syncFile(l.path, l.f)
+	l.syncFile(sidecarPath(l.path), l.sidecar)
+	l.dirty = false
+}
+
+// syncFile syncs f if the store it was opened from writes to disk
+func (l *ChatLog) syncFile(path string, f io.Writer) {
+	if s, ok := f.(interface{ Sync() error }); ok {
+		if err := s.Sync(); err != nil {
+			l.writeError(path, err)
+		}
+	}
+}
+
+func (l *ChatLog) writeError(path string, err error) {
+	log.Printf("error writing %s %s", path, err)
+	LogWriteErrors.Add(l.channel, 1)
+}
+
+// Close release file handle, closing a closed log does nothing
+func (l *ChatLog) Close() {
+	l.Lock()
+	if l.closed {
+		l.Unlock()
+		return
+	}
+	l.closed = true
+	l.writeNicks()
+	l.sync()
 	l.f.Close()
-	if _, err := common.CompressFile(l.f.Name()); !os.IsNotExist(err) && err != nil {
-		log.Printf("error compressing log %s %s", l.f.Name(), err)
+	if l.search != nil {
+		if err := l.search.Close(); err != nil {
+			log.Printf("error closing search index for %s %s", l.path, err)
+		}
+	}
+	if _, err := common.CompressFile(l.path); !os.IsNotExist(err) && err != nil {
+		log.Printf("error compressing log %s %s", l.path, err)
+	}
+	l.sidecar.Close()
+	if _, err := common.CompressFile(sidecarPath(l.path)); !os.IsNotExist(err) && err != nil {
+		log.Printf("error compressing sidecar %s %s", sidecarPath(l.path), err)
 	}
 	l.Unlock()
+	nickIndexes.Write(filepath.Dir(l.path))
 }
 
 func (l *ChatLog) Write(timestamp time.Time, nick string, message string) {
+	line := timestamp.Format("[2006-01-02 15:04:05 MST] ") + nick + ": " + message + "\n"
 	l.Lock()
 	l.nicks.Add(nick)
-	l.f.WriteString(timestamp.Format("[2006-01-02 15:04:05 MST] ") + nick + ": " + message + "\n")
+	nickIndexes.Add(filepath.Dir(l.path), timestamp, nick, len(line))
+	if _, err := io.WriteString(l.f, line); err != nil {
+		l.writeError(l.path, err)
+	} else if l.search != nil {
+		l.search.Add(line)
+	}
+	l.dirty = true
+	l.modified = time.Now()
+	l.Unlock()
+}
+
+// WriteMessage append the full message to the structured sidecar
+func (l *ChatLog) WriteMessage(m *common.Message) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("error encoding message for %s %s", sidecarPath(l.path), err)
+		return
+	}
+	l.Lock()
+	if _, err := l.sidecar.Write(append(data, '\n')); err != nil {
+		l.writeError(sidecarPath(l.path), err)
+	}
+	l.dirty = true
 	l.modified = time.Now()
 	l.Unlock()
 }
@@ -89,6 +214,12 @@ func nickPath(path string) string {
 	return path[:len(path)-len(ext)] + ".nicks"
 }
 
+// sidecarPath structured log path of the day's log, compressed to .jsonl.gz with the .txt
+func sidecarPath(path string) string {
+	ext := filepath.Ext(path)
+	return path[:len(path)-len(ext)] + ".jsonl"
+}
+
 // ChatLogs chat log collection
 type ChatLogs struct {
 	logs *lru.Cache
@@ -103,9 +234,35 @@ func NewChatLogs() *ChatLogs {
 	}
 	l.logs = cache
 	go l.housekeeping()
+	go l.syncLoop(fsyncInterval())
 	return l
 }
 
+// fsyncInterval configured fsync interval
+func fsyncInterval() time.Duration {
+	s := common.GetConfig().FsyncInterval
+	if s == "" {
+		return DefaultFsyncInterval
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		log.Printf("invalid fsyncInterval %q, using %s", s, DefaultFsyncInterval)
+		return DefaultFsyncInterval
+	}
+	return d
+}
+
+func (l *ChatLogs) syncLoop(interval time.Duration) {
+	tick := time.NewTicker(interval)
+	for range tick.C {
+		for _, k := range l.logs.Keys() {
+			if v, ok := l.logs.Peek(k); ok {
+				v.(*ChatLog).Sync()
+			}
+		}
+	}
+}
+
 func (l *ChatLogs) housekeeping() {
 	const interval = 2 * time.Minute
 	tick := time.NewTicker(interval)
@@ -115,13 +272,14 @@ func (l *ChatLogs) housekeeping() {
 				c := v.(*ChatLog)
 				idle := now.Sub(c.Modified())
 				if idle > time.Hour {
+					// closed by HandleEvict
 					l.logs.Remove(k)
-					c.Close()
 				} else if idle < interval {
 					c.WriteNicks()
 				}
 			}
 		}
+		nickIndexes.Evict(time.Hour)
 	}
 }
 
@@ -143,12 +301,26 @@ func (l *ChatLogs) Get(path string) (*ChatLog, error) {
 	return chatLog, nil
 }
 
-// Close close all open chat logs
-func (l *ChatLogs) Close() {
+// Resize change how many logs are kept open, logs over the limit are closed
+func (l *ChatLogs) Resize(maxOpenLogs int) {
+	if evicted := l.logs.Resize(maxOpenLogs / 2); evicted > 0 {
+		log.Printf("closed %d logs after resizing", evicted)
+	}
+}
+
+// Flush flush all open chat logs, it returns the number of logs flushed
+func (l *ChatLogs) Flush() int {
+	var n int
 	for _, k := range l.logs.Keys() {
-		if v, ok := l.logs.Get(k); ok {
-			l.logs.Remove(k)
-			v.(*ChatLog).Close()
+		if v, ok := l.logs.Peek(k); ok {
+			v.(*ChatLog).Flush()
+			n++
 		}
 	}
+	return n
+}
+
+// Close close all open chat logs
+func (l *ChatLogs) Close() {
+	l.logs.Purge()
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+// search lines of the month in dir matching q
+func search(t *testing.T, dir, q string) []string {
+	t.Helper()
+	query, err := common.ParseSearchQuery(q, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	if _, err := common.SearchMonth(dir, query, time.Time{}, time.Time{}, func(r *common.SearchResult) bool {
+		found = append(found, r.Text)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func TestChatLogSearchIndex(t *testing.T) {
+	setTestConfig(t, "maxOpenLogs = 10")
+	dir := filepath.Join(t.TempDir(), "Search chatlog", "January 2017")
+	path := filepath.Join(dir, "2017-01-02.txt")
+	day := time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC)
+	logs := NewChatLogs()
+	l, err := logs.Get(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Write(day, "a", "hello there")
+	l.Write(day.Add(time.Second), "b", "general kenobi")
+	if found := search(t, dir, "kenobi"); len(found) != 0 {
+		t.Errorf("unflushed lines were found, got: %v", found)
+	}
+	logs.Flush()
+	if found := search(t, dir, "kenobi"); len(found) != 1 || !strings.HasSuffix(found[0], "b: general kenobi") {
+		t.Errorf("invalid results after flushing, got: %v", found)
+	}
+
+	// a reopened log continues the index where the closed one stopped
+	logs.Close()
+	if l, err = logs.Get(path); err != nil {
+		t.Fatal(err)
+	}
+	l.Write(day.Add(2*time.Second), "a", "you are a bold one")
+	logs.Close()
+	if found := search(t, dir, "hello OR bold"); len(found) != 2 {
+		t.Errorf("expected lines from both opens, got: %v", found)
+	}
+}
@@ -6,3 +6,5 @@ require (
 	github.com/MemeLabs/overrustlelogs v0.0.0-20200730084753-e0fd58b6bb14
 	github.com/hashicorp/golang-lru v0.5.4
 )
+
+replace github.com/MemeLabs/overrustlelogs => ../
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/MemeLabs/overrustlelogs/common"
@@ -18,13 +22,28 @@ const (
 
 // Logger logger
 type Logger struct {
-	logs *ChatLogs
+	logs   *ChatLogs
+	prefix string
+	root   string
 }
 
-// NewLogger instantiates destiny chat logger
-func NewLogger(logs *ChatLogs) *Logger {
+// NewLogger instantiates destiny chat logger, channel directories are
+// prefixed with prefix if it isn't empty
+func NewLogger(logs *ChatLogs, prefix string) *Logger {
 	return &Logger{
-		logs: logs,
+		logs:   logs,
+		prefix: prefix,
+		root:   LogsPath,
+	}
+}
+
+// Log starts the logging loop matching the source kind
+func (l *Logger) Log(kind string, mc <-chan *common.Message) {
+	switch kind {
+	case "destinygg":
+		l.DestinyLog(mc)
+	default:
+		l.TwitchLog(mc)
 	}
 }
 
@@ -35,6 +54,7 @@ func (l *Logger) DestinyLog(mc <-chan *common.Message) {
 
 loop:
 	for m := range mc {
+		l.writeMessage(m)
 		switch m.Type {
 		case "BAN":
 			l.writeLine(m.Time, m.Channel, "Ban", fmt.Sprintf("%s banned by %s", m.Data, m.Nick))
@@ -69,6 +89,8 @@ loop:
 		case "MSG":
 			l.writeLine(m.Time, m.Channel, m.Nick, m.Data)
 			subTrigger = false
+		case "GAP":
+			l.writeGap(m)
 		}
 	}
 }
@@ -76,17 +98,142 @@ loop:
 // TwitchLog starts logging loop
 func (l *Logger) TwitchLog(mc <-chan *common.Message) {
 	for m := range mc {
-		if m.Type == "MSG" {
+		l.writeMessage(m)
+		switch m.Type {
+		case "MSG":
 			l.writeLine(m.Time, m.Channel, m.Nick, m.Data)
+		case "BAN":
+			l.writeMonthLine(m.Time, m.Channel, common.BansFileName, "Ban", fmt.Sprintf("%s banned", m.Data))
+		case "TIMEOUT":
+			l.writeMonthLine(m.Time, m.Channel, common.BansFileName, "Ban", fmt.Sprintf("%s timed out for %ss", m.Data, m.Tags["ban-duration"]))
+		case "CLEARMSG":
+			l.writeMonthLine(m.Time, m.Channel, common.BansFileName, "Ban", fmt.Sprintf("message from %s deleted: %s", m.Nick, m.Data))
+		case "CLEARCHAT":
+			l.writeMonthLine(m.Time, m.Channel, common.BansFileName, "Ban", "chat cleared")
+		case "GAP":
+			l.writeGap(m)
+		case "USERNOTICE":
+			name, nick := common.EventsFileName, "Event"
+			if m.Tags["msg-id"] == "raid" {
+				name, nick = common.RaidsFileName, "Raid"
+			}
+			l.writeMonthLine(m.Time, m.Channel, name, nick, fmt.Sprintf("[%s] %s", m.Tags["msg-id"], m.Data))
+		}
+	}
+}
+
+var gapsLock sync.Mutex
+
+// writeGap appends the gap to the gaps.jsonl of every month it touches
+func (l *Logger) writeGap(m *common.Message) {
+	gap, err := common.GapFromMessage(m)
+	if err != nil {
+		log.Printf("error reading gap %s", err)
+		return
+	}
+	data, err := json.Marshal(gap)
+	if err != nil {
+		log.Printf("error encoding gap %s", err)
+		return
+	}
+	data = append(data, '\n')
+
+	gapsLock.Lock()
+	defer gapsLock.Unlock()
+	month := time.Date(gap.Start.Year(), gap.Start.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for ; !month.After(gap.End); month = month.AddDate(0, 1, 0) {
+		dir := filepath.Join(l.root, l.channelDir(m.Channel), month.Format("January 2006"))
+		f, err := common.Store().Append(filepath.Join(dir, common.GapsFileName))
+		if err != nil {
+			log.Printf("error opening gaps %s", err)
+			return
+		}
+		if _, err := f.Write(data); err != nil {
+			log.Printf("error writing gap %s", err)
 		}
+		f.Close()
+	}
+}
+
+var monthLinesLock sync.Mutex
+
+// writeMonthLine appends a line to the month log name instead of the day log
+func (l *Logger) writeMonthLine(t time.Time, channel, name, nick, message string) {
+	line := t.Format("[2006-01-02 15:04:05 MST] ") + nick + ": " + message + "\n"
+	path := filepath.Join(l.root, l.channelDir(channel), t.Format("January 2006"), name)
+
+	monthLinesLock.Lock()
+	defer monthLinesLock.Unlock()
+	f, err := common.Store().Append(path)
+	if err != nil {
+		log.Printf("error opening %s %s", path, err)
+		return
+	}
+	if _, err := io.WriteString(f, line); err != nil {
+		log.Printf("error writing %s %s", path, err)
+	}
+	f.Close()
+}
+
+// writeMessage keeps every message with its tags in the day's structured sidecar
+func (l *Logger) writeMessage(m *common.Message) {
+	logs, err := l.logs.Get(l.logPath(m.Channel, m.Time))
+	if err != nil {
+		log.Printf("error opening log %s", err)
+		return
 	}
+	logs.WriteMessage(m)
 }
 
 func (l *Logger) writeLine(timestamp time.Time, channel, nick, message string) {
-	logs, err := l.logs.Get(filepath.Join(LogsPath, strings.Title(channel)+" chatlog", timestamp.Format("January 2006"), timestamp.Format("2006-01-02")+".txt"))
+	logs, err := l.logs.Get(l.logPath(channel, timestamp))
 	if err != nil {
 		log.Printf("error opening log %s", err)
 		return
 	}
 	logs.Write(timestamp, nick, message)
 }
+
+func (l *Logger) logPath(channel string, t time.Time) string {
+	return filepath.Join(l.root, l.channelDir(channel), t.Format("January 2006"), t.Format("2006-01-02")+".txt")
+}
+
+// invalidDirChars characters the server doesn't allow in channel names
+var invalidDirChars = regexp.MustCompile("[^a-zA-Z0-9_-]")
+
+// channelDir log directory of a channel, renamed channels keep their first
+// directory. Channels of prefixed sources aren't twitch channels so they
+// aren't resolved through the alias table.
+func (l *Logger) channelDir(channel string) string {
+	if l.prefix != "" {
+		// irc channels like ##foo or c++ can't be served by the server's routes
+		return strings.Title(invalidDirChars.ReplaceAllString(l.prefix+"-"+channel, "_")) + " chatlog"
+	}
+	return strings.Title(aliases.Resolve(channel)) + " chatlog"
+}
+
+// sourcePrefix channel directory prefix of a source. Irc networks get their
+// own so a #destiny on libera doesn't end up in the twitch Destiny logs.
+func sourcePrefix(sc common.SourceConfig) string {
+	switch sc.Kind {
+	case "irc", "ircs":
+	default:
+		return ""
+	}
+	if sc.Network != "" {
+		return strings.ToLower(sc.Network)
+	}
+	host, _, err := net.SplitHostPort(sc.Server)
+	if err != nil {
+		host = sc.Server
+	}
+	// irc.libera.chat is libera
+	labels := strings.Split(strings.ToLower(host), ".")
+	if len(labels) > 1 {
+		labels = labels[:len(labels)-1]
+	}
+	if len(labels) > 1 && labels[0] == "irc" {
+		labels = labels[1:]
+	}
+	return strings.Join(labels, "-")
+}
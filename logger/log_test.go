@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+// testLogger logger writing to a temp dir with an empty alias table, the
+// dir is returned with it
+func testLogger(t *testing.T, logs *ChatLogs) (*Logger, string) {
+	t.Helper()
+	root := t.TempDir()
+	prev := aliases
+	t.Cleanup(func() { aliases = prev })
+	var err error
+	if aliases, err = common.NewChannelAliases(filepath.Join(root, common.ChannelAliasesFile)); err != nil {
+		t.Fatal(err)
+	}
+	l := NewLogger(logs, "")
+	l.root = root
+	return l, root
+}
+
+func TestChannelDir(t *testing.T) {
+	prev := aliases
+	defer func() { aliases = prev }()
+	var err error
+	aliases, err = common.NewChannelAliases(filepath.Join(t.TempDir(), common.ChannelAliasesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources := []struct {
+		conf common.SourceConfig
+		want string
+	}{
+		{common.SourceConfig{Kind: "twitch"}, "Destiny chatlog"},
+		{common.SourceConfig{Kind: "destinygg"}, "Destiny chatlog"},
+		{common.SourceConfig{Kind: "ircs", Server: "irc.libera.chat:6697"}, "Libera-Destiny chatlog"},
+		{common.SourceConfig{Kind: "irc", Server: "irc.rizon.net"}, "Rizon-Destiny chatlog"},
+		{common.SourceConfig{Kind: "irc", Server: "localhost:6667"}, "Localhost-Destiny chatlog"},
+		{common.SourceConfig{Kind: "irc", Server: "irc.libera.chat:6697", Network: "Libera2"}, "Libera2-Destiny chatlog"},
+	}
+	for _, s := range sources {
+		l := NewLogger(nil, sourcePrefix(s.conf))
+		if got := l.channelDir("destiny"); got != s.want {
+			t.Errorf("invalid dir for %s %s, got: %s; want: %s", s.conf.Kind, s.conf.Server, got, s.want)
+		}
+	}
+
+	l := NewLogger(nil, "libera")
+	for ch, want := range map[string]string{"##destiny": "Libera-__destiny chatlog", "c++": "Libera-C__ chatlog"} {
+		if got := l.channelDir(ch); got != want {
+			t.Errorf("invalid dir for %s, got: %s; want: %s", ch, got, want)
+		}
+	}
+}
+
+func TestLogBackpressure(t *testing.T) {
+	setTestConfig(t, `
+maxOpenLogs = 10
+backpressure = "block"
+`)
+	logs := NewChatLogs()
+	l, root := testLogger(t, logs)
+	messages := make(chan *common.Message, 1)
+	quit := make(chan struct{})
+	sink := common.NewMessageSink(messages, quit)
+	done := make(chan struct{})
+	go func() {
+		l.Log("destinygg", messages)
+		close(done)
+	}()
+
+	// a full buffer blocks the client instead of dropping messages
+	const count = 200
+	day := time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < count; i++ {
+		sink.Push(&common.Message{Type: "MSG", Channel: "Backpressure", Nick: "a", Data: fmt.Sprint(i), Time: day.Add(time.Duration(i) * time.Second)})
+	}
+	sink.Close()
+	<-done
+	logs.Close()
+
+	if v := common.DroppedMessages.Get("Backpressure"); v != nil {
+		t.Errorf("expected no dropped messages, got: %s", v)
+	}
+	data, err := common.ReadCompressedFile(filepath.Join(root, "Backpressure chatlog", "January 2017", "2017-01-02.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != count {
+		t.Errorf("expected %d lines, got: %d", count, n)
+	}
+}
+
+func TestTwitchLogEvents(t *testing.T) {
+	setTestConfig(t, `maxOpenLogs = 10`)
+	logs := NewChatLogs()
+	l, root := testLogger(t, logs)
+	day := time.Date(2017, 1, 2, 8, 0, 0, 0, time.UTC)
+	messages := make(chan *common.Message, 10)
+	for _, m := range []*common.Message{
+		{Type: "MSG", Channel: "events", Nick: "Ban", Data: "not a moderator"},
+		{Type: "BAN", Channel: "events", Data: "bob"},
+		{Type: "TIMEOUT", Channel: "events", Data: "carl", Tags: map[string]string{"ban-duration": "600"}},
+		{Type: "USERNOTICE", Channel: "events", Data: "dave is raiding", Tags: map[string]string{"msg-id": "raid"}},
+		{Type: "USERNOTICE", Channel: "events", Data: "hello", Tags: map[string]string{"msg-id": "announcement"}},
+	} {
+		m.Time = day
+		messages <- m
+	}
+	close(messages)
+	l.Log("twitch", messages)
+	logs.Close()
+
+	dir := filepath.Join(root, "Events chatlog", "January 2017")
+	files := map[string]int{
+		"2017-01-02.txt":      1,
+		common.BansFileName:   2,
+		common.RaidsFileName:  1,
+		common.EventsFileName: 1,
+	}
+	for name, want := range files {
+		var data []byte
+		var err error
+		if strings.HasSuffix(name, ".txt") {
+			data, err = common.ReadCompressedFile(filepath.Join(dir, name))
+		} else {
+			data, err = ioutil.ReadFile(filepath.Join(dir, name))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(data), "\n"); n != want {
+			t.Errorf("expected %d lines in %s, got: %q", want, name, data)
+		}
+	}
+}
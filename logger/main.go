@@ -3,43 +3,109 @@ package main
 import (
 	"flag"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sync"
 	"syscall"
 
 	//"overrustlelogs/common"
 	"github.com/MemeLabs/overrustlelogs/common"
 )
 
+// aliases login to user id table shared with the server
+var aliases *common.ChannelAliases
+
+var configPath string
+
 func init() {
-	configPath := flag.String("config", "/logger/overrustlelogs.toml", "config path")
-	flag.Parse()
-	common.SetupConfig(*configPath)
+	flag.StringVar(&configPath, "config", "/logger/overrustlelogs.toml", "config path")
 }
 
 func main() {
+	flag.Parse()
+	common.SetupConfig(configPath)
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	common.SetStore(common.NewStoreFromConfig(LogsPath))
+
+	var err error
+	aliases, err = common.NewChannelAliases(filepath.Join(LogsPath, common.ChannelAliasesFile))
+	if err != nil {
+		log.Printf("error reading channel aliases %s", err)
+	}
+
+	// message counters are published by common on /debug/vars
+	if addr := common.GetConfig().MetricsAddr; addr != "" {
+		go func() {
+			log.Printf("metrics server stopped %s", http.ListenAndServe(addr, nil))
+		}()
+	}
+
+	sources := common.GetConfig().Sources
+	if len(sources) == 0 {
+		sources = []common.SourceConfig{{Kind: "destinygg"}, {Kind: "twitch"}}
+	}
 
-	logs := NewChatLogs()
+	RecoverLogs(LogsPath)
 
-	dc := common.NewDestiny()
-	dl := NewLogger(logs)
-	go dl.DestinyLog(dc.Messages())
-	go dc.Run()
+	admin := NewAdmin()
+	var chats []common.ChatSource
+	var logs []*ChatLogs
+	var logging sync.WaitGroup
+	for _, sc := range sources {
+		c, err := common.NewChatSource(sc)
+		if err != nil {
+			log.Fatalf("error creating chat source %s", err)
+		}
+		l := NewChatLogs()
+		logging.Add(1)
+		go func(l *Logger, kind string, mc <-chan *common.Message) {
+			defer logging.Done()
+			l.Log(kind, mc)
+		}(NewLogger(l, sourcePrefix(sc)), sc.Kind, c.Messages())
+		c.Run()
+		chats = append(chats, c)
+		logs = append(logs, l)
+		admin.Add(sc.Kind, c, l)
+	}
 
-	twitchLogHandler := func(m <-chan *common.Message) {
-		NewLogger(NewChatLogs()).TwitchLog(m)
+	if addr := common.GetConfig().AdminAddr; addr != "" {
+		if common.GetConfig().AdminToken == "" {
+			log.Println("admin api disabled, adminToken isn't set")
+		} else {
+			go func() {
+				log.Printf("admin server stopped %s", http.ListenAndServe(addr, admin))
+			}()
+		}
 	}
 
-	tl := NewTwitchLogger(twitchLogHandler)
-	go tl.Start()
+	quit := make(chan struct{})
+	reloader := NewReloader(configPath, chats, logs)
+	go reloader.Watch(quit)
+	go archiveLoop(quit)
+	go userDirectoryLoop(quit)
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			reloader.Reload()
+		}
+	}()
 
 	sigint := make(chan os.Signal, 1)
 	signal.Notify(sigint, os.Interrupt, syscall.SIGTERM)
 	<-sigint
-	logs.Close()
-	dc.Stop()
-	tl.Stop()
+	close(quit)
+	// messages the chats still hold are logged before the logs are closed
+	for _, c := range chats {
+		c.Stop()
+	}
+	logging.Wait()
+	for _, l := range logs {
+		l.Close()
+	}
 	log.Println("i love you guys, be careful")
 	os.Exit(0)
 }
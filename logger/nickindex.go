@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+// NickIndexes month nick indexes of the logs being written, shared by every
+// source since the indexes are per month directory
+type NickIndexes struct {
+	sync.Mutex
+	months map[string]*monthIndex
+}
+
+type monthIndex struct {
+	// index is nil while it's loaded in the background
+	index    common.NickIndex
+	dirty    bool
+	modified time.Time
+	// lines added while the index is loaded
+	pending []nickLine
+}
+
+type nickLine struct {
+	t    time.Time
+	nick string
+	size int
+}
+
+var nickIndexes = NewNickIndexes()
+
+// NewNickIndexes new index collection
+func NewNickIndexes() *NickIndexes {
+	return &NickIndexes{months: map[string]*monthIndex{}}
+}
+
+// Add counts a line of the month in dir, the month's index is loaded in the
+// background and lines are kept until it's done
+func (n *NickIndexes) Add(dir string, t time.Time, nick string, size int) {
+	n.Lock()
+	defer n.Unlock()
+	m, ok := n.months[dir]
+	if !ok {
+		m = &monthIndex{}
+		n.months[dir] = m
+		go n.load(dir, m, t.Truncate(time.Second))
+	}
+	if m.index == nil {
+		m.pending = append(m.pending, nickLine{t, nick, size})
+	} else {
+		m.index.Add(nick, t, size)
+		m.dirty = true
+	}
+	m.modified = time.Now()
+}
+
+// load reads the index of dir, months the logger starts in without an index
+// are indexed from the lines logged before cutoff. Lines stamped before
+// cutoff that are written while the logs are read can be missed, the tool
+// rebuilds exact indexes.
+func (n *NickIndexes) load(dir string, m *monthIndex, cutoff time.Time) {
+	index, err := common.ReadNickIndex(common.NickIndexPath(dir))
+	built := err != nil
+	if built {
+		if !os.IsNotExist(err) {
+			log.Printf("error reading nick index, rebuilding %s", err)
+		}
+		index, err = common.BuildNickIndexBefore(dir, cutoff)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("error building nick index of %s %s", dir, err)
+		}
+		if index == nil {
+			index = common.NickIndex{}
+		}
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	for _, l := range m.pending {
+		if !built || !l.t.Before(cutoff) {
+			index.Add(l.nick, l.t, l.size)
+		}
+	}
+	m.dirty = built || len(m.pending) > 0
+	m.index = index
+	m.pending = nil
+}
+
+// Write persists the index of dir if it changed
+func (n *NickIndexes) Write(dir string) {
+	n.Lock()
+	defer n.Unlock()
+	if m, ok := n.months[dir]; ok {
+		n.write(dir, m)
+	}
+}
+
+func (n *NickIndexes) write(dir string, m *monthIndex) {
+	if !m.dirty || m.index == nil {
+		return
+	}
+	if err := m.index.WriteTo(common.NickIndexPath(dir)); err != nil {
+		log.Printf("error writing nick index of %s %s", dir, err)
+		return
+	}
+	m.dirty = false
+}
+
+// Evict persists and drops indexes that weren't written to for idle
+func (n *NickIndexes) Evict(idle time.Duration) {
+	n.Lock()
+	defer n.Unlock()
+	for dir, m := range n.months {
+		if time.Since(m.modified) > idle {
+			n.write(dir, m)
+			if !m.dirty && m.index != nil {
+				delete(n.months, dir)
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+// loadedIndex waits for the index of dir to load and returns it
+func loadedIndex(t *testing.T, n *NickIndexes, dir string) common.NickIndex {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		var index common.NickIndex
+		n.Lock()
+		if m, ok := n.months[dir]; ok {
+			index = m.index
+		}
+		n.Unlock()
+		if index != nil {
+			return index
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("index of %s wasn't loaded", dir)
+	return nil
+}
+
+func TestNickIndexesBuild(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Test chatlog", "January 2017")
+	start := time.Date(2017, 1, 2, 0, 0, 2, 0, time.UTC)
+	// the line being added is already on disk when the logs are read
+	writeTestFile(t, filepath.Join(dir, "2017-01-02.txt"), testLines+"[2017-01-02 00:00:02 UTC] a: three\n")
+
+	n := NewNickIndexes()
+	n.Add(dir, start.Add(500*time.Millisecond), "a", 36)
+	n.Add(dir, start.Add(time.Second), "c", 36)
+	index := loadedIndex(t, n, dir)
+
+	lines := map[string]int{"a": 2, "b": 1, "c": 1}
+	for nick, want := range lines {
+		if s, ok := index.Get(nick); !ok || s.Lines != want {
+			t.Errorf("invalid lines for %s, got: %+v; want: %d", nick, s, want)
+		}
+	}
+
+	n.Write(dir)
+	written, err := common.ReadNickIndex(common.NickIndexPath(dir))
+	if err != nil || len(written) != len(lines) {
+		t.Errorf("invalid written index, got: %v %v", written, err)
+	}
+}
+
+func TestNickIndexesRead(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Test chatlog", "January 2017")
+	writeTestFile(t, filepath.Join(dir, "2017-01-02.txt"), testLines)
+	stored := common.NickIndex{}
+	stored.Add("a", time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), 10)
+	if err := stored.WriteTo(common.NickIndexPath(dir)); err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNickIndexes()
+	n.Add(dir, time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC), "a", 10)
+	index := loadedIndex(t, n, dir)
+	if s, ok := index.Get("a"); !ok || s.Lines != 2 {
+		t.Errorf("expected the stored index and the added line, got: %+v", s)
+	}
+	if _, ok := index.Get("b"); ok {
+		t.Error("logs were read despite a stored index")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+// connection pool settings
+const (
+	RebalanceInterval = 10 * time.Minute
+	// RebalanceThreshold channel count difference between the fullest and
+	// emptiest connection that's tolerated before channels are moved
+	RebalanceThreshold = 10
+)
+
+// chatsNeeded connections needed for n channels
+func chatsNeeded(n int) int {
+	return (n + common.MaxChannelsPerChat - 1) / common.MaxChannelsPerChat
+}
+
+// pickChat returns the connection with the fewest channels, a new connection
+// is started while there are fewer than the channel list needs, chatLock
+// must be held
+func (t *TwitchHub) pickChat() *common.Twitch {
+	if len(t.chats) < chatsNeeded(len(t.Channels())) {
+		return t.newChat()
+	}
+	var chat *common.Twitch
+	var min int
+	for _, c := range t.chats {
+		n := len(c.Channels())
+		if n >= common.MaxChannelsPerChat {
+			continue
+		}
+		if chat == nil || n < min {
+			chat, min = c, n
+		}
+	}
+	if chat == nil {
+		return t.newChat()
+	}
+	return chat
+}
+
+// newChat starts a connection and forwards its messages, chatLock must be held
+func (t *TwitchHub) newChat() *common.Twitch {
+	chat := common.NewTwitch()
+	chat.Run()
+	t.chats = append(t.chats, chat)
+	t.handlers.Add(1)
+	go t.msgHandler(chat)
+	return chat
+}
+
+// move channel moved between connections by rebalance, channels of stopped
+// connections have no from
+type move struct {
+	ch       string
+	from, to *common.Twitch
+}
+
+// rebalance stops connections the channel list doesn't need anymore and
+// evens out the channel counts of the remaining ones. Channels are parted
+// before they're joined elsewhere so no message is logged twice.
+func (t *TwitchHub) rebalance() {
+	t.rebalanceLock.Lock()
+	defer t.rebalanceLock.Unlock()
+
+	// joins wait for the join rate limit, they're sent without chatLock so
+	// the moves don't hold up everything else using the connections
+	for _, m := range t.planRebalance() {
+		select {
+		case <-t.quit:
+			return
+		default:
+		}
+		if m.from != nil {
+			log.Printf("moving %s from %s to %s", m.ch, m.from.Name(), m.to.Name())
+			if err := m.from.Leave(m.ch); err != nil {
+				log.Printf("error leaving %s: %v", m.ch, err)
+				continue
+			}
+		}
+		if err := m.to.Join(m.ch); err != nil {
+			log.Printf("failed to join %s: %v", m.ch, err)
+		}
+	}
+}
+
+// planRebalance stops the connections that aren't needed and picks where
+// their channels and the channels of the fullest connections go, joins in
+// flight are waited for so none of them lands on a stopped connection
+func (t *TwitchHub) planRebalance() []move {
+	t.joinLock.Lock()
+	defer t.joinLock.Unlock()
+	t.chatLock.Lock()
+	defer t.chatLock.Unlock()
+	select {
+	case <-t.quit:
+		return nil
+	default:
+	}
+
+	channels := make(map[*common.Twitch][]string, len(t.chats))
+	for _, c := range t.chats {
+		channels[c] = c.Channels()
+	}
+	sortChats := func() {
+		sort.SliceStable(t.chats, func(i, j int) bool {
+			return len(channels[t.chats[i]]) < len(channels[t.chats[j]])
+		})
+	}
+
+	var moves []move
+	want := chatsNeeded(len(t.Channels()))
+	for len(t.chats) > want {
+		sortChats()
+		c := t.chats[0]
+		t.chats = t.chats[1:]
+		log.Printf("consolidating %s with %d channels", c.Name(), len(channels[c]))
+		c.Stop()
+		for _, ch := range channels[c] {
+			if len(t.chats) == 0 {
+				t.newChat()
+			}
+			sortChats()
+			to := t.chats[0]
+			channels[to] = append(channels[to], ch)
+			moves = append(moves, move{ch: ch, to: to})
+		}
+	}
+
+	for len(t.chats) > 1 {
+		sortChats()
+		min, max := t.chats[0], t.chats[len(t.chats)-1]
+		if len(channels[max])-len(channels[min]) <= RebalanceThreshold {
+			break
+		}
+		ch := channels[max][0]
+		channels[max] = channels[max][1:]
+		channels[min] = append(channels[min], ch)
+		moves = append(moves, move{ch: ch, from: max, to: min})
+	}
+	return moves
+}
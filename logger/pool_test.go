@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+// testHub hub with unconnected chats holding the given channel counts
+func testHub(t *testing.T, counts ...int) *TwitchHub {
+	t.Helper()
+	setTestConfig(t, "")
+	h := &TwitchHub{quit: make(chan struct{})}
+	var n int
+	for _, count := range counts {
+		c := common.NewTwitch()
+		for i := 0; i < count; i++ {
+			ch := fmt.Sprintf("channel%d", n)
+			n++
+			h.channels = append(h.channels, ch)
+			if err := c.Join(ch); err != nil {
+				t.Fatal(err)
+			}
+		}
+		h.chats = append(h.chats, c)
+	}
+	return h
+}
+
+// checkChats checks every channel is joined on exactly one chat
+func checkChats(t *testing.T, h *TwitchHub) {
+	t.Helper()
+	joined := map[string]int{}
+	for _, c := range h.chats {
+		for _, ch := range c.Channels() {
+			joined[ch]++
+		}
+	}
+	for _, ch := range h.Channels() {
+		if joined[ch] != 1 {
+			t.Errorf("%s joined %d times", ch, joined[ch])
+		}
+	}
+	if len(joined) != len(h.Channels()) {
+		t.Errorf("expected %d joined channels, got: %d", len(h.Channels()), len(joined))
+	}
+}
+
+func TestRebalanceConsolidate(t *testing.T) {
+	h := testHub(t, 3, 40, 5)
+	h.rebalance()
+	if len(h.chats) != 1 {
+		t.Fatalf("expected a single chat, got: %d", len(h.chats))
+	}
+	checkChats(t, h)
+}
+
+func TestRebalanceEven(t *testing.T) {
+	h := testHub(t, 50, 0, 30)
+	h.rebalance()
+	if len(h.chats) != 2 {
+		t.Fatalf("expected two chats, got: %d", len(h.chats))
+	}
+	checkChats(t, h)
+	a, b := len(h.chats[0].Channels()), len(h.chats[1].Channels())
+	if d := a - b; d > RebalanceThreshold || d < -RebalanceThreshold {
+		t.Errorf("channels weren't evened out, got: %d and %d", a, b)
+	}
+}
+
+func TestRebalanceStopped(t *testing.T) {
+	h := testHub(t, 10, 30)
+	close(h.quit)
+	h.rebalance()
+	if len(h.chats) != 2 || len(h.chats[0].Channels()) != 10 {
+		t.Errorf("expected no moves after stopping")
+	}
+}
+
+func TestRebalanceJoining(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		h := testHub(t, 3, 20, 5)
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for j := 0; j < 20; j++ {
+			ch := fmt.Sprintf("joining%d", j)
+			h.addChannel(ch)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				if err := h.join(ch, false); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		close(start)
+		h.rebalance()
+		wg.Wait()
+		if len(h.chats) != 1 {
+			t.Fatalf("expected a single chat, got: %d", len(h.chats))
+		}
+		checkChats(t, h)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+// RecoverLogs repairs the logs of the current and previous month, the only
+// ones the logger could have had open when it crashed
+func RecoverLogs(root string) {
+	now := time.Now().UTC()
+	var paths []string
+	for _, month := range []time.Time{now, now.AddDate(0, -1, 0)} {
+		for _, ext := range []string{"*.txt", "*.jsonl"} {
+			matches, err := filepath.Glob(filepath.Join(root, "*", month.Format("January 2006"), ext))
+			if err != nil {
+				log.Printf("error listing logs %s", err)
+				continue
+			}
+			paths = append(paths, matches...)
+		}
+	}
+	for _, path := range paths {
+		if err := recoverLog(path); err != nil {
+			log.Printf("error recovering %s %s", path, err)
+		}
+	}
+
+	// temp files of compressed writes that didn't finish
+	for _, month := range []time.Time{now, now.AddDate(0, -1, 0)} {
+		matches, _ := filepath.Glob(filepath.Join(root, "*", month.Format("January 2006"), "*.tmp[0-9]*"))
+		for _, path := range matches {
+			log.Printf("removing unfinished write %s", path)
+			os.Remove(path)
+		}
+	}
+}
+
+// recoverLog reconciles a log that exists both compressed and uncompressed
+// and trims a torn last line. Compressing removes the plain file after the
+// compressed one is written and uncompressing does the reverse, so the
+// complete copy is whichever decodes to more data.
+func recoverLog(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	gz := path + ".gz"
+	if _, err := os.Stat(gz); err == nil {
+		data, err := common.ReadCompressedFile(gz)
+		if err == nil && int64(len(data)) >= info.Size() {
+			log.Printf("recovering %s from compressed log", path)
+			return os.Remove(path)
+		}
+		log.Printf("removing incomplete compressed log %s", gz)
+		if err := os.Remove(gz); err != nil {
+			return err
+		}
+	}
+	return trimTornLine(path, info.Size())
+}
+
+// trimTornLine truncates everything after the last newline
+func trimTornLine(path string, size int64) error {
+	if size == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	const chunk = 64 * 1024
+	buf := make([]byte, chunk)
+	end := size
+	for end > 0 {
+		start := end - chunk
+		if start < 0 {
+			start = 0
+		}
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i != -1 {
+			end = start + int64(i) + 1
+			break
+		}
+		end = start
+	}
+	if end == size {
+		return nil
+	}
+	log.Printf("trimming %d bytes of torn line from %s", size-end, path)
+	if err := f.Truncate(end); err != nil {
+		return err
+	}
+	return f.Sync()
+}
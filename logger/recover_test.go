@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+const testLines = "[2017-01-02 00:00:00 UTC] a: one\n[2017-01-02 00:00:01 UTC] b: two\n"
+
+func writeTestFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestRecoverTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "2017-01-02.txt")
+	writeTestFile(t, path, testLines+"[2017-01-02 00:00:02 UTC] c: thr")
+	if err := recoverLog(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, path); got != testLines {
+		t.Errorf("torn line wasn't trimmed, got: %q", got)
+	}
+
+	// complete logs and logs without a single full line
+	if err := recoverLog(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, path); got != testLines {
+		t.Errorf("complete log was changed, got: %q", got)
+	}
+	writeTestFile(t, path, "[2017-01-02 00:00:00 UTC] a: o")
+	if err := recoverLog(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, path); got != "" {
+		t.Errorf("expected an empty log, got: %q", got)
+	}
+}
+
+func TestRecoverTruncatedCompressed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "2017-01-02.txt")
+	writeTestFile(t, path, testLines)
+	if _, err := common.WriteCompressedFile(path, []byte(testLines)); err != nil {
+		t.Fatal(err)
+	}
+	gz := readTestFile(t, path+".gz")
+	writeTestFile(t, path+".gz", gz[:len(gz)/2])
+
+	if err := recoverLog(path); err != nil {
+		t.Fatal(err)
+	}
+	if exists(path + ".gz") {
+		t.Error("truncated compressed log wasn't removed")
+	}
+	if got := readTestFile(t, path); got != testLines {
+		t.Errorf("plain log was changed, got: %q", got)
+	}
+}
+
+func TestRecoverCompressed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "2017-01-02.txt")
+	// compressing was interrupted before the plain log was removed, or
+	// uncompressing before anything was appended
+	writeTestFile(t, path, testLines[:10])
+	if _, err := common.WriteCompressedFile(path, []byte(testLines)); err != nil {
+		t.Fatal(err)
+	}
+	if err := recoverLog(path); err != nil {
+		t.Fatal(err)
+	}
+	if exists(path) {
+		t.Error("partial plain log wasn't removed")
+	}
+	data, err := common.ReadCompressedFile(path + ".gz")
+	if err != nil || string(data) != testLines {
+		t.Errorf("compressed log was changed, got: %q %v", data, err)
+	}
+}
+
+func TestRecoverLogs(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "Test chatlog", time.Now().UTC().Format("January 2006"))
+	path := filepath.Join(dir, "2017-01-02.txt")
+	writeTestFile(t, path, testLines+"torn")
+	writeTestFile(t, path+".gz.tmp123456", "partial")
+	writeTestFile(t, filepath.Join(dir, "2017-01-02.jsonl"), "{}\n{")
+
+	RecoverLogs(root)
+	if exists(path + ".gz.tmp123456") {
+		t.Error("orphaned temp file wasn't removed")
+	}
+	if got := readTestFile(t, path); got != testLines {
+		t.Errorf("torn line wasn't trimmed, got: %q", got)
+	}
+	if got := readTestFile(t, filepath.Join(dir, "2017-01-02.jsonl")); got != "{}\n" {
+		t.Errorf("torn sidecar line wasn't trimmed, got: %q", got)
+	}
+}
+
+func TestChatLogCloseTwice(t *testing.T) {
+	setTestConfig(t, "maxOpenLogs = 10")
+	path := filepath.Join(t.TempDir(), "Close chatlog", "January 2017", "2017-01-02.txt")
+	logs := NewChatLogs()
+	l, err := logs.Get(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Write(time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC), "a", "one")
+
+	// removing closes the log through HandleEvict
+	logs.logs.Remove(path)
+	l.Close()
+	logs.Close()
+	if v := LogWriteErrors.Get("Close chatlog"); v != nil {
+		t.Errorf("closing twice counted write errors, got: %s", v)
+	}
+	data, err := common.ReadCompressedFile(path + ".gz")
+	if err != nil || string(data) != "[2017-01-02 00:00:00 UTC] a: one\n" {
+		t.Errorf("invalid compressed log, got: %q %v", data, err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+// ReloadCheckInterval how often the config and channel list are checked for changes
+const ReloadCheckInterval = 10 * time.Second
+
+// Reloader applies config and channel list changes to the running logger
+type Reloader struct {
+	configPath string
+	chats      []common.ChatSource
+	logs       []*ChatLogs
+}
+
+// NewReloader ...
+func NewReloader(configPath string, chats []common.ChatSource, logs []*ChatLogs) *Reloader {
+	return &Reloader{
+		configPath: configPath,
+		chats:      chats,
+		logs:       logs,
+	}
+}
+
+// Watch reload whenever the config or channel list are modified
+func (r *Reloader) Watch(quit <-chan struct{}) {
+	config := modTime(r.configPath)
+	channels := modTime(ChannelListPath)
+
+	tick := time.NewTicker(ReloadCheckInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-tick.C:
+		}
+		if t := modTime(r.configPath); !t.Equal(config) {
+			config = t
+			r.ReloadConfig()
+		}
+		if t := modTime(ChannelListPath); !t.Equal(channels) {
+			channels = t
+			r.ReloadChannels()
+		}
+	}
+}
+
+// Reload reload both the config and channel list
+func (r *Reloader) Reload() {
+	r.ReloadConfig()
+	r.ReloadChannels()
+}
+
+// ReloadConfig parse the config and apply the settings that can change at runtime
+func (r *Reloader) ReloadConfig() {
+	prev := common.GetConfig()
+	conf, err := common.ReloadConfig(r.configPath)
+	if err != nil {
+		log.Printf("error reloading config, keeping the current one %s", err)
+		return
+	}
+	log.Println("reloaded config")
+
+	if conf.MaxOpenLogs != prev.MaxOpenLogs {
+		for _, l := range r.logs {
+			l.Resize(conf.MaxOpenLogs)
+		}
+	}
+	for _, c := range r.chats {
+		if h, ok := c.(*TwitchHub); ok {
+			h.ApplyConfig(conf)
+		}
+	}
+	if !reflect.DeepEqual(conf.Sources, prev.Sources) {
+		log.Println("chat sources changed, restart the logger to apply them")
+	}
+}
+
+// ReloadChannels join and leave channels to match the channel list
+func (r *Reloader) ReloadChannels() {
+	for _, c := range r.chats {
+		if h, ok := c.(*TwitchHub); ok {
+			if err := h.ReloadChannels(); err != nil {
+				log.Printf("error reloading channels %s", err)
+			}
+		}
+	}
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
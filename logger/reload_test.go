@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+func TestSaveChannels(t *testing.T) {
+	dir := t.TempDir()
+	h := &TwitchHub{
+		channels:     []string{"destiny", "athenelive", "lirik"},
+		channelsPath: filepath.Join(dir, "channels.json"),
+	}
+	if err := h.saveChannels(); err != nil {
+		t.Fatal(err)
+	}
+
+	channels, err := readChannels(h.channelsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"athenelive", "destiny", "lirik"}; !reflect.DeepEqual(channels, want) {
+		t.Errorf("invalid saved channels, got: %v; want: %v", channels, want)
+	}
+	if want := []string{"destiny", "athenelive", "lirik"}; !reflect.DeepEqual(h.Channels(), want) {
+		t.Errorf("saving reordered the channels, got: %v", h.Channels())
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected only the channel list, got %d files", len(files))
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overrustlelogs.toml")
+	write := func(conf string) {
+		if err := ioutil.WriteFile(path, []byte(conf), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`
+[twitch]
+admins = ["first"]
+commandChannel = "one"
+`)
+	if _, err := common.ReloadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	h := &TwitchHub{}
+	h.ApplyConfig(common.GetConfig())
+	r := NewReloader(path, []common.ChatSource{h}, nil)
+
+	write(`
+[twitch]
+admins = ["second"]
+commandChannel = "two"
+`)
+	r.ReloadConfig()
+	if _, ok := h.admins["second"]; !ok || len(h.admins) != 1 || h.commandChannel != "two" {
+		t.Errorf("config wasn't applied, got: %v %s", h.admins, h.commandChannel)
+	}
+
+	write(`[twitch`)
+	r.ReloadConfig()
+	if common.GetConfig().Twitch.CommandChannel != "two" || h.commandChannel != "two" {
+		t.Errorf("invalid config replaced the current one, got: %s", h.commandChannel)
+	}
+}
+
+func TestReloadChannels(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth2/token" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token", "expires_in": 3600})
+			return
+		}
+		var users []common.HelixUser
+		for _, login := range r.URL.Query()["login"] {
+			if login == "destiny" {
+				users = append(users, common.HelixUser{ID: "18074328", Login: login})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": users})
+	}))
+	defer s.Close()
+
+	dir := t.TempDir()
+	h := testHub(t, 1)
+	h.helix = common.NewHelix("id", "secret")
+	h.helix.BaseURL = s.URL + "/helix"
+	h.helix.TokenURL = s.URL + "/oauth2/token"
+	var err error
+	h.aliases, err = common.NewChannelAliases(filepath.Join(dir, common.ChannelAliasesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.channelsPath = filepath.Join(dir, "channels.json")
+	if err := ioutil.WriteFile(h.channelsPath, []byte(`["channel0", "destiny", "destinytypo"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.ReloadChannels(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"channel0", "destiny"}; !reflect.DeepEqual(h.Channels(), want) {
+		t.Errorf("invalid channels, got: %v; want: %v", h.Channels(), want)
+	}
+	checkChats(t, h)
+	if id, ok := h.aliases.ID("destiny"); !ok || id != "18074328" {
+		t.Errorf("expected the added channel's id to be recorded, got: %q", id)
+	}
+}
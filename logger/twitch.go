@@ -1,13 +1,10 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net/http"
-	"os"
 	"sort"
 	"strings"
 	"sync"
@@ -21,46 +18,126 @@ const (
 	ChannelListPath = "/logger/channels.json"
 )
 
+// ChannelRefreshInterval how often channel logins are checked for renames
+const ChannelRefreshInterval = 6 * time.Hour
+
+func init() {
+	// replaces the single connection client from common, the hub spreads
+	// channels over as many connections as it needs
+	common.RegisterChatSource("twitch", func(common.SourceConfig) (common.ChatSource, error) {
+		return NewTwitchLogger(), nil
+	})
+}
+
 // TwitchHub ...
 type TwitchHub struct {
 	chatLock       sync.RWMutex
 	chats          []*common.Twitch
+	rebalanceLock  sync.Mutex
+	joinLock       sync.RWMutex // held by joins, rebalance waits for them
 	chLock         sync.RWMutex
 	channels       []string
-	logHandler     func(m <-chan *common.Message)
+	messages       chan *common.Message
+	handlers       sync.WaitGroup
+	confLock       sync.RWMutex
 	admins         map[string]struct{}
 	commandChannel string
+	helix          *common.Helix
+	aliases        *common.ChannelAliases
+	channelsPath   string
 	quit           chan struct{}
 }
 
 // NewTwitchLogger ...
-func NewTwitchLogger(f func(m <-chan *common.Message)) *TwitchHub {
+func NewTwitchLogger() *TwitchHub {
 	t := &TwitchHub{
-		logHandler:     f,
-		admins:         make(map[string]struct{}),
-		commandChannel: common.GetConfig().Twitch.CommandChannel,
-		quit:           make(chan struct{}, 1),
+		messages:     make(chan *common.Message, common.MessageBufferSize),
+		helix:        common.NewHelixFromConfig(),
+		aliases:      aliases,
+		channelsPath: ChannelListPath,
+		quit:         make(chan struct{}, 1),
 	}
+	t.ApplyConfig(common.GetConfig())
 
-	admins := common.GetConfig().Twitch.Admins
-	for _, a := range admins {
-		t.admins[a] = struct{}{}
+	channels, err := readChannels(t.channelsPath)
+	if err != nil {
+		log.Fatalf("unable to read channels %s", err)
 	}
+	t.channels = channels
+	return t
+}
 
-	d, err := ioutil.ReadFile("/logger/channels.json")
+func readChannels(path string) ([]string, error) {
+	d, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Fatalf("unable to read channels %s", err)
+		return nil, err
 	}
-	if err := json.Unmarshal(d, &t.channels); err != nil {
-		log.Fatalf("unable to read channels %s", err)
+	var channels []string
+	if err := json.Unmarshal(d, &channels); err != nil {
+		return nil, err
 	}
-	return t
+	return channels, nil
+}
+
+// ApplyConfig update admins and the command channel
+func (t *TwitchHub) ApplyConfig(conf *common.Config) {
+	admins := make(map[string]struct{}, len(conf.Twitch.Admins))
+	for _, a := range conf.Twitch.Admins {
+		admins[a] = struct{}{}
+	}
+	t.confLock.Lock()
+	t.admins = admins
+	t.commandChannel = conf.Twitch.CommandChannel
+	t.confLock.Unlock()
+}
+
+// ReloadChannels joins channels added to and leaves channels removed from the channel list
+func (t *TwitchHub) ReloadChannels() error {
+	channels, err := readChannels(t.channelsPath)
+	if err != nil {
+		return err
+	}
+
+	current := t.Channels()
+	for _, ch := range channels {
+		if inSlice(current, ch) {
+			continue
+		}
+		if err := t.checkChannel(ch); err != nil {
+			log.Println(err)
+			continue
+		}
+		t.addChannel(ch)
+		if err := t.join(ch, false); err != nil {
+			log.Println(err)
+		}
+	}
+	for _, ch := range current {
+		if inSlice(channels, ch) {
+			continue
+		}
+		if err := t.removeChannel(ch); err != nil {
+			log.Println(err)
+			continue
+		}
+		if err := t.leaveChat(ch); err != nil {
+			log.Println(err)
+		}
+	}
+	t.rebalance()
+	return nil
+}
+
+// Run joins the saved channels in the background
+func (t *TwitchHub) Run() {
+	go t.start()
 }
 
-// Start ...
-func (t *TwitchHub) Start() {
+func (t *TwitchHub) start() {
+	t.refreshChannels()
+
 	var c int
-	for _, channel := range t.channels {
+	for _, channel := range t.Channels() {
 		select {
 		case <-t.quit:
 			return
@@ -74,6 +151,91 @@ func (t *TwitchHub) Start() {
 		c++
 	}
 	log.Printf("joined %d chats, wew lad :^)\n", c)
+
+	refresh := time.NewTicker(ChannelRefreshInterval)
+	defer refresh.Stop()
+	rebalance := time.NewTicker(RebalanceInterval)
+	defer rebalance.Stop()
+	for {
+		select {
+		case <-t.quit:
+			return
+		case <-refresh.C:
+			t.refreshChannels()
+		case <-rebalance.C:
+			t.rebalance()
+		}
+	}
+}
+
+// refreshChannels records the user id of every channel and follows renames
+func (t *TwitchHub) refreshChannels() {
+	var ids, logins []string
+	for _, ch := range t.Channels() {
+		if id, ok := t.aliases.ID(ch); ok {
+			ids = append(ids, id)
+		} else {
+			logins = append(logins, ch)
+		}
+	}
+
+	users, err := t.helix.UsersByID(ids...)
+	if err != nil {
+		log.Printf("error looking up channel ids %s", err)
+		return
+	}
+	byLogin, err := t.helix.Users(logins...)
+	if err != nil {
+		log.Printf("error looking up channel logins %s", err)
+		return
+	}
+	users = append(users, byLogin...)
+
+	for _, u := range users {
+		prev, renamed := t.aliases.Update(u.ID, u.Login)
+		if !renamed {
+			continue
+		}
+		log.Printf("%s renamed to %s", prev, u.Login)
+		t.rename(prev, u.Login)
+	}
+	if err := t.aliases.Save(); err != nil {
+		log.Printf("error saving channel aliases %s", err)
+	}
+}
+
+// rename moves a channel to its new login, logs keep going to the
+// directory the alias table resolves both logins to
+func (t *TwitchHub) rename(prev, login string) {
+	if err := t.removeChannel(prev); err != nil {
+		log.Println(err)
+		return
+	}
+	t.addChannel(login)
+	if err := t.saveChannels(); err != nil {
+		return
+	}
+
+	t.joinLock.RLock()
+	defer t.joinLock.RUnlock()
+	t.chatLock.RLock()
+	var chat *common.Twitch
+	for _, c := range t.chats {
+		if inSlice(c.Channels(), prev) {
+			chat = c
+			break
+		}
+	}
+	t.chatLock.RUnlock()
+	if chat == nil {
+		return
+	}
+	if err := chat.Leave(prev); err != nil {
+		log.Printf("error leaving %s: %v", prev, err)
+	}
+	if err := chat.Join(login); err != nil {
+		log.Printf("failed to join %s: %v", login, err)
+	}
 }
 
 // Stop ...
@@ -85,14 +247,70 @@ func (t *TwitchHub) Stop() {
 	wg.Add(len(t.chats))
 	for i, c := range t.chats {
 		log.Printf("stopping chat: %d\n", i)
-		go c.Stop(&wg)
+		go func(c *common.Twitch) {
+			c.Stop()
+			wg.Done()
+		}(c)
 	}
 	t.chatLock.Unlock()
 	wg.Wait()
+	t.handlers.Wait()
+	close(t.messages)
+}
+
+// Join start logging ch
+func (t *TwitchHub) Join(ch string) error {
+	return t.join(ch, true)
+}
+
+// Leave stop logging ch
+func (t *TwitchHub) Leave(ch string) error {
+	return t.leave(ch)
+}
+
+// Channels logged channels
+func (t *TwitchHub) Channels() []string {
+	t.chLock.RLock()
+	defer t.chLock.RUnlock()
+	channels := make([]string, len(t.channels))
+	copy(channels, t.channels)
+	return channels
+}
+
+// Connections state and channel count of every connection
+func (t *TwitchHub) Connections() []Connection {
+	t.chatLock.RLock()
+	defer t.chatLock.RUnlock()
+	conns := make([]Connection, len(t.chats))
+	for i, c := range t.chats {
+		conns[i] = Connection{
+			Name:     c.Name(),
+			State:    c.State().String(),
+			Channels: len(c.Channels()),
+		}
+	}
+	return conns
+}
+
+// Reconnect reconnect every connection
+func (t *TwitchHub) Reconnect() {
+	t.chatLock.RLock()
+	defer t.chatLock.RUnlock()
+	for _, c := range t.chats {
+		c.Reconnect()
+	}
+}
+
+// Messages messages from every connection
+func (t *TwitchHub) Messages() <-chan *common.Message {
+	return t.messages
 }
 
 func (t *TwitchHub) runCommand(c *common.Twitch, m *common.Message) {
-	if _, ok := t.admins[m.Nick]; !ok || m.Type != "MSG" {
+	t.confLock.RLock()
+	_, ok := t.admins[m.Nick]
+	t.confLock.RUnlock()
+	if !ok || m.Type != "MSG" {
 		return
 	}
 
@@ -116,34 +334,23 @@ func (t *TwitchHub) runCommand(c *common.Twitch, m *common.Message) {
 }
 
 func (t *TwitchHub) join(ch string, init bool) error {
-	if inSlice(t.channels, ch) && init {
+	if init && inSlice(t.Channels(), ch) {
 		return fmt.Errorf("already logging %s", ch)
 	}
 
-	exists, _ := channelExists(ch)
-	if !exists && init {
-		return fmt.Errorf("%s doesn't exist my dude", ch)
-	}
-
 	if init {
+		if err := t.checkChannel(ch); err != nil {
+			return err
+		}
 		t.addChannel(ch)
 		go t.saveChannels()
 	}
 
+	// the chat can't be stopped by a rebalance before ch is on it
+	t.joinLock.RLock()
+	defer t.joinLock.RUnlock()
 	t.chatLock.Lock()
-	var chat *common.Twitch
-	for _, c := range t.chats {
-		if len(c.Channels()) >= common.MaxChannelsPerChat {
-			continue
-		}
-		chat = c
-	}
-	if chat == nil {
-		chat = common.NewTwitch()
-		chat.Run()
-		t.chats = append(t.chats, chat)
-		go t.msgHandler(chat)
-	}
+	chat := t.pickChat()
 	t.chatLock.Unlock()
 	if err := chat.Join(ch); err != nil {
 		return fmt.Errorf("failed to join %s: %v", ch, err)
@@ -152,17 +359,37 @@ func (t *TwitchHub) join(ch string, init bool) error {
 	return nil
 }
 
+// checkChannel makes sure ch exists and records its id to follow renames
+func (t *TwitchHub) checkChannel(ch string) error {
+	u, err := t.helix.User(ch)
+	if err == common.ErrUserNotFound {
+		return fmt.Errorf("%s doesn't exist my dude", ch)
+	}
+	if err != nil {
+		return fmt.Errorf("error looking up %s: %v", ch, err)
+	}
+	if prev, renamed := t.aliases.Update(u.ID, u.Login); renamed {
+		log.Printf("%s renamed to %s", prev, u.Login)
+	}
+	if err := t.aliases.Save(); err != nil {
+		log.Printf("error saving channel aliases %s", err)
+	}
+	return nil
+}
+
+// msgHandler forwards the messages of c until its channel is closed, stopped
+// connections still hand over what they spilled
 func (t *TwitchHub) msgHandler(c *common.Twitch) {
-	messages := make(chan *common.Message, common.MessageBufferSize)
-	go t.logHandler(messages)
-	for {
+	defer t.handlers.Done()
+	for m := range c.Messages() {
+		t.messages <- m
+		t.confLock.RLock()
+		command := t.commandChannel == m.Channel
+		t.confLock.RUnlock()
 		select {
 		case <-t.quit:
-			close(messages)
-			return
-		case m := <-c.Messages():
-			messages <- m
-			if t.commandChannel == m.Channel {
+		default:
+			if command {
 				go t.runCommand(c, m)
 			}
 		}
@@ -176,7 +403,15 @@ func (t *TwitchHub) leave(ch string) error {
 	if err := t.saveChannels(); err != nil {
 		return err
 	}
+	if err := t.leaveChat(ch); err != nil {
+		return err
+	}
+	go t.rebalance()
+	return nil
+}
 
+// leaveChat parts ch on the connection it was joined on
+func (t *TwitchHub) leaveChat(ch string) error {
 	t.chatLock.Lock()
 	defer t.chatLock.Unlock()
 	for _, c := range t.chats {
@@ -212,74 +447,19 @@ func (t *TwitchHub) removeChannel(ch string) error {
 	return fmt.Errorf("didn't find %s in the channels list", ch)
 }
 
+// saveChannels replaces the channel list, the reloader never reads a partly
+// written list
 func (t *TwitchHub) saveChannels() error {
-	f, err := os.Create("/logger/channels.json")
-	if err != nil {
-		log.Printf("error saving channel list %s", err)
-		return err
+	channels := t.Channels()
+	sort.Strings(channels)
+	data, err := json.MarshalIndent(channels, "", "\t")
+	if err == nil {
+		_, err = common.WriteFileAtomic(t.channelsPath, data)
 	}
-	defer f.Close()
-
-	t.chLock.RLock()
-	sort.Strings(t.channels)
-	data, err := json.Marshal(t.channels)
 	if err != nil {
 		log.Printf("error saving channel list %s", err)
-		return err
-	}
-	t.chLock.RUnlock()
-
-	var buf bytes.Buffer
-	if err := json.Indent(&buf, data, "", "\t"); err != nil {
-		log.Printf("error saving channel list %s", err)
-		return err
 	}
-	f.Write(buf.Bytes())
-	return nil
-}
-
-var client = http.Client{
-	Timeout: 5 * time.Second,
-}
-
-type usersResponse struct {
-	Total int `json:"_total"`
-	Users []struct {
-		ID          string    `json:"_id"`
-		Bio         string    `json:"bio"`
-		CreatedAt   time.Time `json:"created_at"`
-		DisplayName string    `json:"display_name"`
-		Logo        string    `json:"logo"`
-		Name        string    `json:"name"`
-		Type        string    `json:"type"`
-		UpdatedAt   time.Time `json:"updated_at"`
-	} `json:"users"`
-}
-
-// channelExists
-func channelExists(ch string) (bool, string) {
-	req, err := http.NewRequest("GET", "https://api.twitch.tv/kraken/users?login="+strings.ToLower(ch), nil)
-	if err != nil {
-		return false, ""
-	}
-	req.Header.Add("Accept", "application/vnd.twitchtv.v5+json")
-	req.Header.Add("Client-ID", common.GetConfig().Twitch.ClientID)
-	res, err := client.Do(req)
-	if err != nil {
-		return false, ""
-	}
-
-	if res.StatusCode != http.StatusOK {
-		return false, ""
-	}
-
-	var us usersResponse
-	err = json.NewDecoder(res.Body).Decode(&us)
-	if us.Total == 0 {
-		return false, ""
-	}
-
-	return true, us.Users[0].ID
+	return err
 }
 
 func inSlice(slice []string, s string) bool {
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+// UserDirectoryInterval how often the current months' nick indexes are
+// merged into the global user directory
+const UserDirectoryInterval = time.Hour
+
+// userDirectoryLoop keeps the user directory up to date with the months
+// being logged, `tool userdir` rebuilds it from every month
+func userDirectoryLoop(quit <-chan struct{}) {
+	t := time.NewTicker(UserDirectoryInterval)
+	defer t.Stop()
+	for {
+		if err := common.UpdateUserDirectory(LogsPath, time.Now().UTC()); err != nil {
+			log.Printf("error updating user directory %s", err)
+		}
+		select {
+		case <-quit:
+			return
+		case <-t.C:
+		}
+	}
+}
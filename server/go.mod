@@ -8,3 +8,5 @@ require (
 	github.com/gorilla/mux v1.8.0
 	github.com/sirupsen/logrus v1.8.1
 )
+
+replace github.com/MemeLabs/overrustlelogs => ../
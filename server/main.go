@@ -9,7 +9,6 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
@@ -34,6 +33,12 @@ const (
 	LogLinePrefixLength = len("[2017-01-10 08:57:47 UTC] ")
 	ViewsPath           = "./views"
 	MaxStalkLines       = 200
+	LogReadBufferSize   = 64 * 1024
+	// DefaultSearchResults and MaxSearchResults results per search page
+	DefaultSearchResults = 50
+	MaxSearchResults     = 200
+	// SearchTimeout searches return what they found so far after this long
+	SearchTimeout = 5 * time.Second
 )
 
 // errors
@@ -42,7 +47,12 @@ var (
 	ErrDayNotFound       = errors.New("cou find logs for this day")
 	ErrNotFound          = errors.New("file not found")
 	ErrSearchKeyNotFound = errors.New("didn't find what you were looking for")
+	ErrInvalidTime       = errors.New("invalid time, expected 15:04 or 15:04:05")
+	ErrInvalidDate       = errors.New("invalid date, expected 2006-01-02")
 	ErrNoSubscribers     = errors.New("no subscribers for this month")
+	ErrNoBans            = errors.New("no bans for this month")
+	ErrNoRaids           = errors.New("no raids for this month")
+	ErrNoEvents          = errors.New("no events for this month")
 	ErrNoMentions        = errors.New("couldn't find any mentions")
 )
 
@@ -60,20 +70,45 @@ type APIError struct {
 
 var dev = false
 
+// configPath optional logger config, its s3 settings make archived months readable
+var configPath string
+
+// aliases resolves renamed channels to their log directory
+var aliases *common.ChannelAliases
+
 var view *jet.Set
 
 func init() {
 	flag.BoolVar(&dev, "dev", false, "for jet template hot reloading and local asset loading")
 	flag.StringVar(&LogsPath, "logs", "/logs", "logs path for easier development")
-	flag.Parse()
+	flag.StringVar(&configPath, "config", "", "logger config, archived logs are read from its s3 bucket")
 }
 
 // Start server
 func main() {
+	flag.Parse()
 	log.SetFormatter(&log.TextFormatter{
 		ForceColors:   true,
 		FullTimestamp: true,
 	})
+	if configPath != "" {
+		common.SetupConfig(configPath)
+		common.SetStore(common.NewStoreFromConfig(LogsPath))
+	}
+
+	var err error
+	aliases, err = common.NewChannelAliases(filepath.Join(LogsPath, common.ChannelAliasesFile))
+	if err != nil {
+		log.Errorf("error reading channel aliases %s", err)
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := aliases.Reload(); err != nil {
+				log.Errorf("error reading channel aliases %s", err)
+			}
+		}
+	}()
+
 	view = jet.NewHTMLSet(ViewsPath)
 	view.SetDevelopmentMode(dev)
 	setupViewGlobals()
@@ -86,6 +121,9 @@ func main() {
 	r.HandleFunc("/changelog", ChangelogHandle).Methods("GET")
 	r.HandleFunc("/stalk", StalkerHandle).Methods("GET").Queries("channel", "{channel:[a-zA-Z0-9_-]+}", "nick", "{nick:@?[a-zA-Z0-9_-]+}")
 	r.HandleFunc("/stalk", StalkerHandle).Methods("GET")
+	r.HandleFunc("/users", UserDirectoryHandle).Methods("GET").Queries("nick", "{nick:@?[a-zA-Z0-9_-]+}")
+	r.HandleFunc("/users", UserDirectoryHandle).Methods("GET")
+	r.HandleFunc("/users/{nick:[a-zA-Z0-9_-]{1,25}}", UserDirectoryHandle).Methods("GET")
 	r.HandleFunc("/mentions/{nick:[a-zA-Z0-9_-]{1,25}}.txt", MentionsHandle).Methods("GET").Queries("date", "{date:[0-9]{4}-[0-9]{2}-[0-9]{2}}")
 	r.HandleFunc("/mentions/{nick:[a-zA-Z0-9_-]{1,25}}.txt", MentionsHandle).Methods("GET")
 	r.HandleFunc("/mentions/{nick:[a-zA-Z0-9_-]{1,25}}", MentionsWrapperHandle).Methods("GET").Queries("date", "{date:[0-9]{4}-[0-9]{2}-[0-9]{2}}")
@@ -123,6 +161,15 @@ func main() {
 	r.HandleFunc("/{channel:[a-zA-Z0-9_-]+ chatlog}/{month:[a-zA-Z]+ [0-9]{4}}/subscribers.txt", SubscriberHandle).Methods("GET").Queries("filter", "{filter:.+}").Methods("GET")
 	r.HandleFunc("/{channel:[a-zA-Z0-9_-]+ chatlog}/{month:[a-zA-Z]+ [0-9]{4}}/subscribers.txt", SubscriberHandle).Methods("GET")
 	r.HandleFunc("/{channel:[a-zA-Z0-9_-]+ chatlog}/{month:[a-zA-Z]+ [0-9]{4}}/subscribers", WrapperHandle).Methods("GET")
+	r.HandleFunc("/{channel:[a-zA-Z0-9_-]+ chatlog}/{month:[a-zA-Z]+ [0-9]{4}}/bans.txt", BanHandle).Methods("GET").Queries("filter", "{filter:.+}").Methods("GET")
+	r.HandleFunc("/{channel:[a-zA-Z0-9_-]+ chatlog}/{month:[a-zA-Z]+ [0-9]{4}}/bans.txt", BanHandle).Methods("GET")
+	r.HandleFunc("/{channel:[a-zA-Z0-9_-]+ chatlog}/{month:[a-zA-Z]+ [0-9]{4}}/bans", WrapperHandle).Methods("GET")
+	r.HandleFunc("/{channel:[a-zA-Z0-9_-]+ chatlog}/{month:[a-zA-Z]+ [0-9]{4}}/raids.txt", RaidHandle).Methods("GET").Queries("filter", "{filter:.+}").Methods("GET")
+	r.HandleFunc("/{channel:[a-zA-Z0-9_-]+ chatlog}/{month:[a-zA-Z]+ [0-9]{4}}/raids.txt", RaidHandle).Methods("GET")
+	r.HandleFunc("/{channel:[a-zA-Z0-9_-]+ chatlog}/{month:[a-zA-Z]+ [0-9]{4}}/raids", WrapperHandle).Methods("GET")
+	r.HandleFunc("/{channel:[a-zA-Z0-9_-]+ chatlog}/{month:[a-zA-Z]+ [0-9]{4}}/events.txt", EventHandle).Methods("GET").Queries("filter", "{filter:.+}").Methods("GET")
+	r.HandleFunc("/{channel:[a-zA-Z0-9_-]+ chatlog}/{month:[a-zA-Z]+ [0-9]{4}}/events.txt", EventHandle).Methods("GET")
+	r.HandleFunc("/{channel:[a-zA-Z0-9_-]+ chatlog}/{month:[a-zA-Z]+ [0-9]{4}}/events", WrapperHandle).Methods("GET")
 	r.NotFoundHandler = http.HandlerFunc(NotFoundHandle)
 	if dev || os.Getenv("DEV") == "true" {
 		r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", http.FileServer(http.Dir("./assets"))))
@@ -130,9 +177,12 @@ func main() {
 
 	api := r.PathPrefix("/api/v1").Subrouter()
 	api.HandleFunc("/channels.json", ChannelsAPIHandle).Methods("GET")
+	api.HandleFunc("/search", SearchAPIHandle).Methods("GET")
+	api.HandleFunc("/users/{nick:[a-zA-Z0-9_-]{1,25}}.json", UserDirectoryAPIHandle).Methods("GET")
 	api.HandleFunc("/{channel:[a-zA-Z0-9_-]+}/months.json", MonthsAPIHandle).Methods("GET")
 	api.HandleFunc("/{channel:[a-zA-Z0-9_-]+}/{month:[a-zA-Z]+ [0-9]{4}}/days.json", DaysAPIHandle).Methods("GET")
 	api.HandleFunc("/{channel:[a-zA-Z0-9_-]+}/{month:[a-zA-Z]+ [0-9]{4}}/users.json", UsersAPIHandle).Methods("GET")
+	api.HandleFunc("/{channel:[a-zA-Z0-9_-]+}/{month:[a-zA-Z]+ [0-9]{4}}/gaps.json", GapsAPIHandle).Methods("GET")
 	api.HandleFunc("/{channel:[a-zA-Z0-9_-]+} chatlog/{month:[a-zA-Z]+ [0-9]{4}}/lines.json", LinesAPIHandle).Methods("GET")
 	api.HandleFunc("/stalk/{channel:[a-zA-Z0-9_-]+}/{nick:[a-zA-Z0-9_-]+}.json", StalkHandle).Queries("limit", "{limit:[0-9]+}").Methods("GET")
 	api.HandleFunc("/stalk/{channel:[a-zA-Z0-9_-]+}/{nick:[a-zA-Z0-9_-]+}.json", StalkHandle).Methods("GET")
@@ -202,7 +252,7 @@ func BaseHandle(w http.ResponseWriter, r *http.Request) {
 		serveError(w, err)
 		return
 	}
-	serveDirIndex(w, []string{}, paths)
+	serveDirIndex(w, []string{}, channelDirs(paths))
 }
 
 // WrapperHandle static html log wrapper
@@ -285,10 +335,7 @@ func MonthHandle(w http.ResponseWriter, r *http.Request) {
 		serveError(w, err)
 		return
 	}
-	metaPaths := []string{"userlogs", "broadcaster.txt", "subscribers.txt"}
-	if vars["channel"] == "Destinygg chatlog" {
-		metaPaths = append(metaPaths, "bans.txt")
-	}
+	metaPaths := []string{"userlogs", "broadcaster.txt", "subscribers.txt", "bans.txt", "raids.txt", "events.txt"}
 	sort.Sort(byDay(paths))
 	paths = append(paths, metaPaths...)
 	copy(paths[len(metaPaths):], paths)
@@ -299,37 +346,45 @@ func MonthHandle(w http.ResponseWriter, r *http.Request) {
 	serveDirIndex(w, []string{convertChannelCase(vars["channel"]), vars["month"]}, paths)
 }
 
-// DayHandle channel index
+// DayHandle channel index, the optional from and to query params (15:04 or
+// 15:04:05 UTC) limit the lines to part of the day
 func DayHandle(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	data, err := readLogFile(filepath.Join(LogsPath, convertChannelCase(vars["channel"]), vars["month"], vars["date"]))
+	start, end, err := dayRange(vars["date"], r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := openLogRange(filepath.Join(LogsPath, convertChannelCase(vars["channel"]), vars["month"], vars["date"]), start, end)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	defer data.Close()
+
+	filter := func([]byte) bool { return true }
+	_, ok := vars["filter"]
+	if ok {
+		if filter, _, _, err = lineFilter(vars["filter"], func(line []byte) bool {
+			return filterKey(line, vars["filter"])
+		}); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	w.Header().Set("Content-type", "text/plain; charset=UTF-8")
 	w.Header().Set("Cache-control", "max-age=60")
-	var ok bool
-	var filter = func(l []byte, f string) bool { return true }
-	if _, ok = vars["filter"]; ok {
-		filter = filterKey
-	}
 	var lineCount int
-	reader := bufio.NewReaderSize(bytes.NewReader(data), len(data))
-	for {
-		line, err := reader.ReadSlice('\n')
-		if err != nil {
-			if err != io.EOF {
-				log.Errorf("error reading bytes %s", err)
-			}
-			break
+	eachLine(data, func(line []byte) {
+		if !lineBetween(line, start, end) {
+			return
 		}
-		if filter(line, vars["filter"]) {
+		if filter(line) {
 			_, _ = w.Write(line)
 			lineCount++
 		}
-	}
+	})
 	if lineCount == 0 && ok {
 		http.Error(w, ErrSearchKeyNotFound.Error(), http.StatusNotFound)
 	}
@@ -338,27 +393,15 @@ func DayHandle(w http.ResponseWriter, r *http.Request) {
 // UsersHandle channel index .
 func UsersHandle(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	f, err := os.Open(filepath.Join(LogsPath, convertChannelCase(vars["channel"]), vars["month"]))
-	if err != nil {
-		serveError(w, ErrNotFound)
-		return
-	}
-	files, err := f.Readdir(0)
+	nicks, err := monthNicks(filepath.Join(LogsPath, convertChannelCase(vars["channel"]), vars["month"]))
 	if err != nil {
 		serveError(w, err)
 		return
 	}
-	nicks := common.NickList{}
-	for _, file := range files {
-		if NicksExtension.MatchString(file.Name()) {
-			_ = common.ReadNickList(nicks, filepath.Join(LogsPath, convertChannelCase(vars["channel"]), vars["month"], file.Name()))
-		}
-	}
 	names := make([]string, 0, len(nicks))
-	for nick := range nicks {
+	for _, nick := range nicks {
 		names = append(names, nick+".txt")
 	}
-	sort.Strings(names)
 	serveDirIndex(w, []string{convertChannelCase(vars["channel"]), vars["month"], "userlogs"}, names)
 }
 
@@ -371,11 +414,35 @@ func UserHandle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, ErrUserNotFound.Error(), http.StatusNotFound)
 		return
 	}
-	if _, ok := vars["filter"]; ok {
-		serveFilteredLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), searchKey(nick, vars["filter"]))
-		return
+	serveNickLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), nick, vars["filter"])
+}
+
+// monthNicks sorted nicks that wrote in a month, read from the month's nick
+// index or the daily nick lists if it has none
+func monthNicks(path string) ([]string, error) {
+	var names []string
+	if idx, err := common.ReadNickIndex(common.NickIndexPath(path)); err == nil {
+		for _, s := range idx {
+			names = append(names, s.Nick)
+		}
+		sort.Strings(names)
+		return names, nil
 	}
-	serveFilteredLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), nickFilter(nick))
+	files, err := readDirIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	nicks := common.NickList{}
+	for _, file := range files {
+		if NicksExtension.MatchString(file) {
+			_ = common.ReadNickList(nicks, filepath.Join(path, file))
+		}
+	}
+	for nick := range nicks {
+		names = append(names, nick)
+	}
+	sort.Strings(names)
+	return names, nil
 }
 
 func userInMonth(channel, nick, month string) (string, bool) {
@@ -400,11 +467,7 @@ func BroadcasterHandle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, ErrUserNotFound.Error(), http.StatusInternalServerError)
 		return
 	}
-	if _, ok := vars["filter"]; ok {
-		serveFilteredLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), searchKey(nick, vars["filter"]))
-		return
-	}
-	serveFilteredLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), nickFilter(nick))
+	serveNickLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), nick, vars["filter"])
 }
 
 // SubscriberHandle channel index
@@ -416,11 +479,28 @@ func SubscriberHandle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, ErrNoSubscribers.Error(), http.StatusInternalServerError)
 		return
 	}
-	if _, ok := vars["filter"]; ok {
-		serveFilteredLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), searchKey(nick, vars["filter"]))
-		return
-	}
-	serveFilteredLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), nickFilter(nick))
+	serveNickLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), nick, vars["filter"])
+}
+
+// BanHandle channel moderation log
+func BanHandle(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	path := filepath.Join(LogsPath, convertChannelCase(vars["channel"]), vars["month"], common.BansFileName)
+	serveMonthLog(w, path, vars["filter"], ErrNoBans)
+}
+
+// RaidHandle channel raid log
+func RaidHandle(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	path := filepath.Join(LogsPath, convertChannelCase(vars["channel"]), vars["month"], common.RaidsFileName)
+	serveMonthLog(w, path, vars["filter"], ErrNoRaids)
+}
+
+// EventHandle channel event log (announcements, bits badges, rituals, ...)
+func EventHandle(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	path := filepath.Join(LogsPath, convertChannelCase(vars["channel"]), vars["month"], common.EventsFileName)
+	serveMonthLog(w, path, vars["filter"], ErrNoEvents)
 }
 
 // DestinyBroadcasterHandle destiny logs
@@ -432,11 +512,7 @@ func DestinyBroadcasterHandle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, ErrUserNotFound.Error(), http.StatusInternalServerError)
 		return
 	}
-	if _, ok := vars["filter"]; ok {
-		serveFilteredLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), searchKey(nick, vars["filter"]))
-		return
-	}
-	serveFilteredLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), nickFilter(nick))
+	serveNickLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), nick, vars["filter"])
 }
 
 // DestinySubscriberHandle destiny subscriber logs
@@ -448,11 +524,7 @@ func DestinySubscriberHandle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, ErrNoSubscribers.Error(), http.StatusInternalServerError)
 		return
 	}
-	if _, ok := vars["filter"]; ok {
-		serveFilteredLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), searchKey(nick, vars["filter"]))
-		return
-	}
-	serveFilteredLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), nickFilter(nick))
+	serveNickLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), nick, vars["filter"])
 }
 
 // DestinyBanHandle channel ban list
@@ -464,11 +536,7 @@ func DestinyBanHandle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, ErrUserNotFound.Error(), http.StatusInternalServerError)
 		return
 	}
-	if _, ok := vars["filter"]; ok {
-		serveFilteredLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), searchKey(nick, vars["filter"]))
-		return
-	}
-	serveFilteredLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), nickFilter(nick))
+	serveNickLogs(w, filepath.Join(LogsPath, vars["channel"], vars["month"]), nick, vars["filter"])
 }
 
 // CurrentBaseHandle shows the most recent months logs directly on the subdomain
@@ -482,7 +550,18 @@ func convertChannelCase(ch string) string {
 	if strings.Contains(ch, " chatlog") {
 		ch = ch[:len(ch)-8]
 	}
-	return strings.Title(strings.ToLower(ch)) + " chatlog"
+	return strings.Title(strings.ToLower(aliases.Resolve(ch))) + " chatlog"
+}
+
+// channelDirs filters the channel directories from the logs root
+func channelDirs(names []string) []string {
+	var dirs []string
+	for _, name := range names {
+		if strings.HasSuffix(name, " chatlog") {
+			dirs = append(dirs, name)
+		}
+	}
+	return dirs
 }
 
 // NickHandle shows the users most recent available log
@@ -557,26 +636,19 @@ func MentionsWrapperHandle(w http.ResponseWriter, r *http.Request) {
 			Name: day.Format("2006-01-02"),
 		}
 		payload.Days = append(payload.Days, &d)
-		data, err := readLogFile(filepath.Join(LogsPath, convertChannelCase(vars["channel"]), day.Format("January 2006"), day.Format("2006-01-02")))
+		data, err := openLogFile(filepath.Join(LogsPath, convertChannelCase(vars["channel"]), day.Format("January 2006"), day.Format("2006-01-02")))
 		if err != nil {
 			d.Log = err.Error()
 			continue
 		}
 		var lineCount int
-		reader := bufio.NewReaderSize(bytes.NewReader(data), len(data))
-		for {
-			line, err := reader.ReadSlice('\n')
-			if err != nil {
-				if err != io.EOF {
-					log.Errorf("error reading bytes %s", err)
-				}
-				break
-			}
+		eachLine(data, func(line []byte) {
 			if isMentioned([]byte(vars["nick"]), line) {
 				d.Log += string(line)
 				lineCount++
 			}
-		}
+		})
+		data.Close()
 		if lineCount == 0 {
 			d.Log = ErrNoMentions.Error()
 		}
@@ -609,27 +681,20 @@ func MentionsHandle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "can't look into the future", http.StatusNotFound)
 		return
 	}
-	data, err := readLogFile(filepath.Join(LogsPath, convertChannelCase(vars["channel"]), t.Format("January 2006"), t.Format("2006-01-02")))
+	data, err := openLogFile(filepath.Join(LogsPath, convertChannelCase(vars["channel"]), t.Format("January 2006"), t.Format("2006-01-02")))
 	if err != nil {
 		http.Error(w, ErrDayNotFound.Error(), http.StatusNotFound)
 		return
 	}
+	defer data.Close()
 	w.Header().Set("Content-type", "text/plain; charset=UTF-8")
 	var lineCount int
-	reader := bufio.NewReaderSize(bytes.NewReader(data), len(data))
-	for {
-		line, err := reader.ReadSlice('\n')
-		if err != nil {
-			if err != io.EOF {
-				log.Errorf("error reading bytes %s", err)
-			}
-			break
-		}
+	eachLine(data, func(line []byte) {
 		if isMentioned([]byte(vars["nick"]), line) {
 			_, _ = w.Write(line)
 			lineCount++
 		}
-	}
+	})
 	if lineCount == 0 {
 		http.Error(w, ErrNoMentions.Error(), http.StatusNotFound)
 	}
@@ -673,26 +738,19 @@ func MentionsAPIHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data, err := readLogFile(filepath.Join(LogsPath, convertChannelCase(vars["channel"]), t.Format("January 2006"), t.Format("2006-01-02")))
+	data, err := openLogFile(filepath.Join(LogsPath, convertChannelCase(vars["channel"]), t.Format("January 2006"), t.Format("2006-01-02")))
 	if err != nil {
 		serveAPIError(w, ErrDayNotFound.Error(), http.StatusNotFound)
 		return
 	}
 
 	var lines [][]byte
-	reader := bufio.NewReaderSize(bytes.NewReader(data), len(data))
-	for {
-		line, err := reader.ReadSlice('\n')
-		if err != nil {
-			if err != io.EOF {
-				log.Errorf("error reading bytes %s", err)
-			}
-			break
-		}
+	eachLine(data, func(line []byte) {
 		if isMentioned([]byte(vars["nick"]), line) {
-			lines = append(lines, line)
+			lines = append(lines, append([]byte(nil), line...))
 		}
-	}
+	})
+	data.Close()
 	if len(lines) == 0 {
 		serveAPIError(w, ErrNoMentions.Error(), http.StatusNotFound)
 		return
@@ -751,6 +809,7 @@ func ChannelsAPIHandle(w http.ResponseWriter, r *http.Request) {
 		serveAPIError(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	files = channelDirs(files)
 
 	for i, v := range files {
 		files[i] = v[:len(v)-8]
@@ -763,7 +822,7 @@ func ChannelsAPIHandle(w http.ResponseWriter, r *http.Request) {
 // MonthsAPIHandle lists the channels
 func MonthsAPIHandle(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	monthsPath := filepath.Join(LogsPath, strings.Title(strings.ToLower(vars["channel"]))+" chatlog")
+	monthsPath := filepath.Join(LogsPath, convertChannelCase(vars["channel"]))
 	files, err := readDirIndex(monthsPath)
 	if err != nil {
 		serveAPIError(w, err.Error(), http.StatusNotFound)
@@ -779,24 +838,18 @@ func MonthsAPIHandle(w http.ResponseWriter, r *http.Request) {
 func DaysAPIHandle(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	daysPath := filepath.Join(LogsPath, strings.Title(strings.ToLower(vars["channel"]))+" chatlog", vars["month"])
+	daysPath := filepath.Join(LogsPath, convertChannelCase(vars["channel"]), vars["month"])
 	files, err := readDirIndex(daysPath)
 	if err != nil {
 		serveAPIError(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	metaLogs := []string{"broadcaster.txt", "subscribers.txt"}
-	if strings.EqualFold(convertChannelCase(vars["channel"]), "destinygg") {
-		metaLogs = append(metaLogs, "bans.txt")
-	}
+	metaLogs := []string{"broadcaster.txt", "subscribers.txt", "bans.txt", "raids.txt", "events.txt"}
 
 	var temp []string
 	for _, v := range files {
-		if strings.Contains(v, ".nicks") {
-			continue
-		}
-		if strings.Contains(v, ".gz") {
+		if strings.HasSuffix(v, ".txt.gz") {
 			temp = append(temp, v[:len(v)-3])
 		}
 	}
@@ -813,7 +866,7 @@ func DaysAPIHandle(w http.ResponseWriter, r *http.Request) {
 // LinesAPIHandle lists the channels
 func LinesAPIHandle(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	monthPath := filepath.Join(LogsPath, strings.Title(strings.ToLower(vars["channel"]))+" chatlog", vars["month"])
+	monthPath := filepath.Join(LogsPath, convertChannelCase(vars["channel"]), vars["month"])
 	files, err := readDirIndex(monthPath)
 	if err != nil {
 		serveAPIError(w, err.Error(), http.StatusNotFound)
@@ -825,11 +878,16 @@ func LinesAPIHandle(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		if strings.Contains(v, ".txt.gz") {
-			b, err := readLogFile(filepath.Join(monthPath, v))
+			data, err := openLogFile(filepath.Join(monthPath, v))
+			if err != nil {
+				continue
+			}
+			lines, err := countLines(data)
+			data.Close()
 			if err != nil {
+				log.Errorf("error reading %s %s", v, err)
 				continue
 			}
-			lines := bytes.Count(b, []byte("\n"))
 			df := "2006-01-02"
 			d, err := time.Parse(df, v[:len(df)])
 			if err != nil {
@@ -869,28 +927,51 @@ func (a ByDate) Less(i, j int) bool {
 // UsersAPIHandle returns the */userlogs directory in json format
 func UsersAPIHandle(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	usersPath := filepath.Join(LogsPath, strings.Title(strings.ToLower(vars["channel"]))+" chatlog", vars["month"])
-	files, err := readDirIndex(usersPath)
+	nicks, err := monthNicks(filepath.Join(LogsPath, convertChannelCase(vars["channel"]), vars["month"]))
 	if err != nil {
 		serveAPIError(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	nicks := common.NickList{}
-	for _, file := range files {
-		if NicksExtension.MatchString(file) {
-			_ = common.ReadNickList(nicks, filepath.Join(LogsPath, strings.Title(strings.ToLower(vars["channel"]))+" chatlog", vars["month"], file))
-		}
-	}
 	names := make([]string, 0, len(nicks))
-	for nick := range nicks {
+	for _, nick := range nicks {
 		names = append(names, nick+".txt")
 	}
-	sort.Strings(names)
 
 	w.Header().Set("Content-type", "application/json")
 	_ = json.NewEncoder(w).Encode(names)
 }
 
+// GapsAPIHandle lists the periods the logger was disconnected from a channel
+func GapsAPIHandle(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	monthPath := filepath.Join(LogsPath, convertChannelCase(vars["channel"]), vars["month"])
+	if _, err := readDirIndex(monthPath); err != nil {
+		serveAPIError(w, ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	gaps := []common.Gap{}
+	f, err := common.Store().Open(filepath.Join(monthPath, common.GapsFileName))
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var gap common.Gap
+			if err := json.Unmarshal(scanner.Bytes(), &gap); err != nil {
+				log.Errorf("error decoding gap %s", err)
+				continue
+			}
+			gaps = append(gaps, gap)
+		}
+	} else if !os.IsNotExist(err) {
+		serveAPIError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(gaps)
+}
+
 // StalkHandle return n most recent lines of chat for user
 func StalkHandle(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -925,32 +1006,30 @@ ScanLogs:
 			serveAPIError(w, err.Error(), http.StatusNotFound)
 			return
 		}
-		data, err := readLogFile(filepath.Join(LogsPath, convertChannelCase(vars["channel"]), rs.Month(), rs.Day()))
-		if err != nil {
-			serveAPIError(w, err.Error(), http.StatusNotFound)
-			return
-		}
-		var lines [][]byte
-		r := bufio.NewReaderSize(bytes.NewReader(data), len(data))
+		// frames are read newest first so only the tail of the day is decoded
 		filter := nickFilter(rs.Nick())
-		for {
-			line, err := r.ReadSlice('\n')
-			if err != nil {
-				if err != io.EOF {
-					log.Errorf("error reading bytes %s", err)
+		err = eachLogFrameReverse(filepath.Join(LogsPath, convertChannelCase(vars["channel"]), rs.Month(), rs.Day()), func(data io.Reader) bool {
+			var lines []string
+			eachLine(data, func(line []byte) {
+				if filter(line) {
+					lines = append(lines, string(line[0:len(line)-1]))
+				}
+			})
+			for i := len(lines) - 1; i >= 0; i-- {
+				index--
+				buf[index] = lines[i]
+				if index == 0 {
+					return false
 				}
-				break
-			}
-			if filter(line) {
-				lines = append(lines, line[0:len(line)-1])
 			}
+			return true
+		})
+		if err != nil {
+			serveAPIError(w, err.Error(), http.StatusNotFound)
+			return
 		}
-		for i := len(lines) - 1; i >= 0; i-- {
-			index--
-			buf[index] = string(lines[i])
-			if index == 0 {
-				break ScanLogs
-			}
+		if index == 0 {
+			break ScanLogs
 		}
 	}
 
@@ -1026,11 +1105,10 @@ func gzPath(path string) string {
 }
 
 func readDirIndex(path string) ([]string, error) {
-	f, err := os.Open(path)
-	if err != nil {
+	names, err := common.Store().ReadDir(path)
+	if os.IsNotExist(err) {
 		return nil, ErrNotFound
 	}
-	names, err := f.Readdirnames(0)
 	if err != nil {
 		return nil, err
 	}
@@ -1039,11 +1117,7 @@ func readDirIndex(path string) ([]string, error) {
 }
 
 func readLogDir(path string) ([]string, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, ErrNotFound
-	}
-	files, err := f.Readdirnames(0)
+	files, err := readDirIndex(path)
 	if err != nil {
 		return nil, err
 	}
@@ -1057,23 +1131,140 @@ func readLogDir(path string) ([]string, error) {
 	return names, nil
 }
 
-func readLogFile(path string) ([]byte, error) {
-	var buf []byte
+// openLogFile streams a day log, compressed or not
+func openLogFile(path string) (io.ReadCloser, error) {
 	path = LogExtension.ReplaceAllString(path, "")
-	buf, err := common.ReadCompressedFile(path + ".txt")
+	r, err := common.OpenLog(path + ".txt")
 	if os.IsNotExist(err) {
-		f, err := os.Open(path + ".txt")
-		if os.IsNotExist(err) {
-			return nil, ErrNotFound
+		return nil, ErrNotFound
+	}
+	return r, err
+}
+
+// openLogRange streams the part of a day log that may hold lines between
+// start and end, the whole log is read when it has no frame index or the
+// range is empty
+func openLogRange(path string, start, end time.Time) (io.ReadCloser, error) {
+	if start.IsZero() && end.IsZero() {
+		return openLogFile(path)
+	}
+	path = LogExtension.ReplaceAllString(path, ".txt")
+	idx, err := common.ReadLogIndex(path)
+	if err != nil || len(idx.Frames) == 0 {
+		return openLogFile(path)
+	}
+	if end.IsZero() {
+		end = idx.Frames[len(idx.Frames)-1].Start.Add(24 * time.Hour)
+	}
+	from, to := idx.Between(start, end)
+	return common.OpenLogFrames(path, idx, from, to)
+}
+
+// eachLogFrameReverse calls fn with every frame of a day log, newest first,
+// until it returns false. Logs without a frame index are read as one frame.
+func eachLogFrameReverse(path string, fn func(r io.Reader) bool) error {
+	path = LogExtension.ReplaceAllString(path, ".txt")
+	idx, err := common.ReadLogIndex(path)
+	if err != nil {
+		data, err := openLogFile(path)
+		if err != nil {
+			return err
 		}
-		buf, err = ioutil.ReadAll(f)
+		defer data.Close()
+		fn(data)
+		return nil
+	}
+	for i := len(idx.Frames) - 1; i >= 0; i-- {
+		data, err := common.OpenLogFrames(path, idx, i, i+1)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		more := fn(data)
+		data.Close()
+		if !more {
+			break
+		}
+	}
+	return nil
+}
+
+// dayRange parses the from and to times of a day, either may be empty. Both
+// are inclusive, a to without seconds includes the whole minute.
+func dayRange(date, from, to string) (start, end time.Time, err error) {
+	parse := func(v string, last time.Duration) (time.Time, error) {
+		if v == "" {
+			return time.Time{}, nil
+		}
+		if t, err := time.Parse("2006-01-02 15:04:05", date+" "+v); err == nil {
+			return t, nil
+		}
+		if t, err := time.Parse("2006-01-02 15:04", date+" "+v); err == nil {
+			return t.Add(last), nil
+		}
+		return time.Time{}, ErrInvalidTime
+	}
+	if start, err = parse(from, 0); err != nil {
+		return
+	}
+	end, err = parse(to, time.Minute-time.Second)
+	return
+}
+
+// lineBetween checks the timestamp of a line against a range, zero bounds
+// are open
+func lineBetween(line []byte, start, end time.Time) bool {
+	if start.IsZero() && end.IsZero() {
+		return true
+	}
+	if len(line) < LogLinePrefixLength {
+		return false
+	}
+	t, err := time.Parse("2006-01-02 15:04:05 MST", string(line[1:24]))
+	if err != nil {
+		return false
+	}
+	return !t.Before(start) && (end.IsZero() || !t.After(end))
+}
+
+// eachLine calls fn with every complete line of r, the line is only valid
+// until fn returns
+func eachLine(r io.Reader, fn func(line []byte)) {
+	reader := bufio.NewReaderSize(r, LogReadBufferSize)
+	var long []byte
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			long = append(long, line...)
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Errorf("error reading bytes %s", err)
+			}
+			return
+		}
+		if long != nil {
+			line = append(long, line...)
+			long = nil
+		}
+		fn(line)
+	}
+}
+
+// countLines counts the newlines in r
+func countLines(r io.Reader) (int, error) {
+	buf := make([]byte, LogReadBufferSize)
+	var n int
+	for {
+		c, err := r.Read(buf)
+		n += bytes.Count(buf[:c], []byte("\n"))
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
 		}
-	} else if err != nil {
-		return nil, err
 	}
-	return buf, nil
 }
 
 func nickFilter(nick string) func([]byte) bool {
@@ -1086,6 +1277,77 @@ func nickFilter(nick string) func([]byte) bool {
 	}
 }
 
+// serveNickLogs serves the lines of nick in a month, narrowed down by a
+// filter query if it isn't empty
+func serveNickLogs(w http.ResponseWriter, path, nick, query string) {
+	filter := nickFilter(nick)
+	var start, end time.Time
+	if query != "" {
+		f, s, e, err := lineFilter(query, searchKey(nick, query))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		start, end = s, e
+		byNick := filter
+		filter = func(line []byte) bool {
+			return byNick(line) && f(line)
+		}
+	}
+	serveFilteredLogs(w, path, filter, start, end)
+}
+
+// serveMonthLog serves a month log the logger keeps apart from the day logs,
+// narrowed down by a filter query if it isn't empty
+func serveMonthLog(w http.ResponseWriter, path, query string, notFound error) {
+	filter := func([]byte) bool { return true }
+	var start, end time.Time
+	if query != "" {
+		var err error
+		if filter, start, end, err = lineFilter(query, func(line []byte) bool {
+			return filterKey(line, query)
+		}); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	f, err := common.Store().Open(path)
+	if os.IsNotExist(err) {
+		http.Error(w, notFound.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-type", "text/plain; charset=UTF-8")
+	w.Header().Set("Cache-control", "max-age=60")
+	eachLine(f, func(line []byte) {
+		if lineBetween(line, start, end) && filter(line) {
+			_, _ = w.Write(line)
+		}
+	})
+}
+
+// lineFilter compiles a filter query, queries without filter operators use
+// plain instead so text searches keep matching like they did before the
+// query language
+func lineFilter(query string, plain func([]byte) bool) (filter func([]byte) bool, start, end time.Time, err error) {
+	if !common.HasFilterOperators(query) {
+		return plain, start, end, nil
+	}
+	f, err := common.CompileFilter(query)
+	if err != nil {
+		return nil, start, end, err
+	}
+	start, end = f.Range()
+	return f.Match, start, end, nil
+}
+
+// searchKey matches the messages of nick containing filter, case insensitive
 func searchKey(nick, filter string) func([]byte) bool {
 	return func(line []byte) bool {
 		msg, err := common.ParseMessageLine(string(line))
@@ -1099,6 +1361,7 @@ func searchKey(nick, filter string) func([]byte) bool {
 	}
 }
 
+// filterKey matches lines containing f, case insensitive
 func filterKey(line []byte, f string) bool {
 	return bytes.Contains(bytes.ToLower(line), bytes.ToLower([]byte(f)))
 }
@@ -1178,7 +1441,7 @@ func serveDirIndex(w http.ResponseWriter, base []string, paths []string) {
 	}
 }
 
-func serveFilteredLogs(w http.ResponseWriter, path string, filter func([]byte) bool) {
+func serveFilteredLogs(w http.ResponseWriter, path string, filter func([]byte) bool, start, end time.Time) {
 	logs, err := readLogDir(path)
 	if err != nil {
 		http.Error(w, ErrNotFound.Error(), http.StatusNotFound)
@@ -1188,24 +1451,23 @@ func serveFilteredLogs(w http.ResponseWriter, path string, filter func([]byte) b
 	w.Header().Set("Content-type", "text/plain; charset=UTF-8")
 	w.Header().Set("Cache-control", "max-age=60")
 	for _, name := range logs {
-		data, err := readLogFile(filepath.Join(path, name))
+		// days outside the filter's dates can't have matching lines
+		if day, err := time.Parse("2006-01-02", strings.SplitN(name, ".", 2)[0]); err == nil {
+			if (!start.IsZero() && !day.Add(24*time.Hour).After(start)) || (!end.IsZero() && !day.Before(end)) {
+				continue
+			}
+		}
+		data, err := openLogFile(filepath.Join(path, name))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		r := bufio.NewReaderSize(bytes.NewReader(data), len(data))
-		for {
-			line, err := r.ReadSlice('\n')
-			if err != nil {
-				if err != io.EOF {
-					log.Errorf("error reading bytes %s", err)
-				}
-				break
-			}
+		eachLine(data, func(line []byte) {
 			if filter(line) {
 				_, _ = w.Write(line)
 			}
-		}
+		})
+		data.Close()
 	}
 }
 
@@ -1265,11 +1527,15 @@ func getToplistPayload(channel, month, limitquery, sortquery string) (topListPay
 	tpl.Breadcrumbs = append(tpl.Breadcrumbs, breadcrumb{"/" + channel + "/" + month, month})
 	tpl.Breadcrumbs = append(tpl.Breadcrumbs, breadcrumb{"/" + channel + "/" + month + "/top" + limitquery, "Top" + limitquery})
 
-	fi, err := os.Stat(path)
+	f, err := common.Store().Open(path)
 	if err != nil {
 		return tpl, errors.New("check back at the end of the month")
 	}
-	tpl.Generated = fi.ModTime().UTC().Format("2006-01-02 15:04:05 MST")
+	f.Close()
+	// archived toplists don't keep their modification time
+	if fi, err := os.Stat(path); err == nil {
+		tpl.Generated = fi.ModTime().UTC().Format("2006-01-02 15:04:05 MST")
+	}
 
 	data, err := common.ReadCompressedFile(path)
 	if err != nil {
@@ -1431,9 +1697,250 @@ func StalkerHandle(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// SearchAPIHandle full text search of a channel's indexed months, newest
+// first. q takes words, "phrases", OR, -word and NOT word, nick, from and to
+// narrow it down and page and limit page through the results.
+func SearchAPIHandle(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	channel := strings.TrimSpace(query.Get("channel"))
+	if channel == "" {
+		serveAPIError(w, "missing channel", http.StatusBadRequest)
+		return
+	}
+	q, err := common.ParseSearchQuery(query.Get("q"), strings.TrimPrefix(strings.TrimSpace(query.Get("nick")), "@"))
+	if err != nil {
+		serveAPIError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var from, to time.Time
+	for _, v := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if d := query.Get(v.name); d != "" {
+			if *v.t, err = time.Parse("2006-01-02", d); err != nil {
+				serveAPIError(w, ErrInvalidDate.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	page, limit := 1, DefaultSearchResults
+	if v := query.Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			serveAPIError(w, "invalid page", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			serveAPIError(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > MaxSearchResults {
+			limit = MaxSearchResults
+		}
+	}
+
+	channelDir := convertChannelCase(channel)
+	months, err := common.Months(common.Store(), LogsPath, channelDir)
+	if err != nil {
+		serveAPIError(w, ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	type Result struct {
+		Channel   string `json:"channel"`
+		Date      string `json:"date"`
+		Line      int    `json:"line"`
+		Timestamp int64  `json:"timestamp"`
+		Nick      string `json:"nick"`
+		Text      string `json:"text"`
+		URL       string `json:"url"`
+	}
+	data := struct {
+		Results []Result `json:"results"`
+		Page    int      `json:"page"`
+		Next    string   `json:"next,omitempty"`
+		Partial bool     `json:"partial,omitempty"`
+	}{Results: []Result{}, Page: page}
+
+	name := strings.TrimSuffix(channelDir, " chatlog")
+	skip := (page - 1) * limit
+	deadline := time.Now().Add(SearchTimeout)
+	var more bool
+	for i := len(months) - 1; i >= 0; i-- {
+		m, _ := time.Parse("January 2006", months[i])
+		if (!to.IsZero() && m.After(to)) || (!from.IsZero() && m.AddDate(0, 1, 0).Before(from)) {
+			continue
+		}
+		cont, err := common.SearchMonth(filepath.Join(LogsPath, channelDir, months[i]), q, from, to, func(res *common.SearchResult) bool {
+			if time.Now().After(deadline) {
+				data.Partial = true
+				return false
+			}
+			if skip > 0 {
+				skip--
+				return true
+			}
+			if len(data.Results) == limit {
+				more = true
+				return false
+			}
+			ts := res.Time.Format("15:04:05")
+			data.Results = append(data.Results, Result{
+				Channel:   name,
+				Date:      res.Time.Format("2006-01-02"),
+				Line:      res.Line,
+				Timestamp: res.Time.Unix(),
+				Nick:      res.Nick,
+				Text:      res.Text,
+				URL:       "/" + channelDir + "/" + months[i] + "/" + res.Time.Format("2006-01-02") + ".txt?from=" + ts + "&to=" + ts,
+			})
+			return true
+		})
+		if err != nil {
+			log.Errorf("error searching %s %s %s", channelDir, months[i], err)
+			serveAPIError(w, "failed searching logs", http.StatusInternalServerError)
+			return
+		}
+		if !cont {
+			break
+		}
+	}
+	if more {
+		query.Set("page", strconv.Itoa(page+1))
+		data.Next = "/api/v1/search?" + query.Encode()
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+// UserDirectoryHandle channels a nick wrote in
+func UserDirectoryHandle(w http.ResponseWriter, r *http.Request) {
+	nick := strings.TrimSpace(strings.TrimPrefix(mux.Vars(r)["nick"], "@"))
+
+	t, err := view.GetTemplate("users")
+	if err != nil {
+		serveError(w, errors.New("failed loading users template"))
+		return
+	}
+
+	var upl userDirectoryPayload
+	upl.Nick = nick
+	if nick != "" {
+		e, err := common.LookupUser(LogsPath, nick)
+		if err == common.ErrNickNotFound {
+			upl.Error = fmt.Sprintf("Couldn't find Nick: %s in any channel", nick)
+		} else if err != nil {
+			log.Errorf("error reading user directory %s", err)
+			upl.Error = "Couldn't read the user directory"
+		} else {
+			upl.Nick = e.Nick
+			upl.Lines = e.Lines()
+			upl.KiloBytes = fmt.Sprintf("%.1f", float32(e.Bytes())/1024)
+			for _, c := range e.Channels {
+				uc := userDirectoryChannel{
+					Channel:   c.Channel,
+					Lines:     c.Lines(),
+					KiloBytes: fmt.Sprintf("%.1f", float32(c.Bytes())/1024),
+					Days:      c.Days(),
+					First:     c.First().Format("2006-01-02"),
+					Last:      c.Last().Format("2006-01-02"),
+				}
+				for i := len(c.Months) - 1; i >= 0; i-- {
+					uc.Months = append(uc.Months, c.Months[i].First.Format("January 2006"))
+				}
+				upl.Channels = append(upl.Channels, uc)
+			}
+		}
+	}
+
+	w.Header().Set("Content-type", "text/html")
+	if err := t.Execute(w, nil, upl); err != nil {
+		serveError(w, errors.New("failed executing users template"))
+	}
+}
+
+// UserDirectoryAPIHandle channels and months a nick wrote in
+func UserDirectoryAPIHandle(w http.ResponseWriter, r *http.Request) {
+	e, err := common.LookupUser(LogsPath, mux.Vars(r)["nick"])
+	if err == common.ErrNickNotFound {
+		serveAPIError(w, ErrUserNotFound.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Errorf("error reading user directory %s", err)
+		serveAPIError(w, "failed reading user directory", http.StatusInternalServerError)
+		return
+	}
+
+	type Month struct {
+		Month string `json:"month"`
+		Lines int    `json:"lines"`
+		Bytes int    `json:"bytes"`
+		Days  []int  `json:"days"`
+		First int64  `json:"first"`
+		Last  int64  `json:"last"`
+	}
+	type Channel struct {
+		Channel string  `json:"channel"`
+		Lines   int     `json:"lines"`
+		Bytes   int     `json:"bytes"`
+		Days    int     `json:"days"`
+		First   int64   `json:"first"`
+		Last    int64   `json:"last"`
+		Months  []Month `json:"months"`
+	}
+	data := struct {
+		Nick     string    `json:"nick"`
+		Lines    int       `json:"lines"`
+		Bytes    int       `json:"bytes"`
+		Channels []Channel `json:"channels"`
+	}{
+		Nick:     e.Nick,
+		Lines:    e.Lines(),
+		Bytes:    e.Bytes(),
+		Channels: []Channel{},
+	}
+	for _, c := range e.Channels {
+		ch := Channel{
+			Channel: c.Channel,
+			Lines:   c.Lines(),
+			Bytes:   c.Bytes(),
+			Days:    c.Days(),
+			First:   c.First().Unix(),
+			Last:    c.Last().Unix(),
+		}
+		for _, m := range c.Months {
+			ch.Months = append(ch.Months, Month{
+				Month: m.First.Format("January 2006"),
+				Lines: m.Lines,
+				Bytes: m.Bytes,
+				Days:  m.ActiveDays(),
+				First: m.First.Unix(),
+				Last:  m.Last.Unix(),
+			})
+		}
+		data.Channels = append(data.Channels, ch)
+	}
+	w.Header().Set("Content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(data)
+}
+
 type (
 	stalkPayload struct {
 		Months               []string
 		Nick, Channel, Error string
 	}
+	userDirectoryPayload struct {
+		Nick, Error, KiloBytes string
+		Lines                  int
+		Channels               []userDirectoryChannel
+	}
+	userDirectoryChannel struct {
+		Channel, KiloBytes, First, Last string
+		Lines, Days                     int
+		// Months newest first
+		Months []string
+	}
 )
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+	"github.com/gorilla/mux"
+)
+
+const testDay = "[2017-01-10 08:00:00 UTC] Alice: check https://example.com PepeLaugh\n" +
+	"[2017-01-10 12:30:00 UTC] bob: hey @alice, how's it going\n" +
+	"[2017-01-10 12:30:59 UTC] Alice: bob: not bad\n" +
+	"[2017-01-10 12:31:00 UTC] Ban: bob banned for 600 seconds\n"
+
+// setTestLogs points LogsPath at a temp dir holding the January 2017 logs
+// of the Test channel, it returns the month's directory
+func setTestLogs(t *testing.T, days map[string]string) string {
+	t.Helper()
+	prevPath, prevAliases := LogsPath, aliases
+	t.Cleanup(func() { LogsPath, aliases = prevPath, prevAliases })
+	LogsPath = t.TempDir()
+	var err error
+	if aliases, err = common.NewChannelAliases(filepath.Join(LogsPath, common.ChannelAliasesFile)); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(LogsPath, "Test chatlog", "January 2017")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range days {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// serve calls h with the route vars and returns the response
+func serve(h http.HandlerFunc, target string, vars map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h(w, mux.SetURLVars(httptest.NewRequest("GET", target, nil), vars))
+	return w
+}
+
+func TestDayHandleFilter(t *testing.T) {
+	setTestLogs(t, map[string]string{"2017-01-10.txt": testDay})
+	cases := []struct {
+		filter string
+		code   int
+		lines  int
+	}{
+		// plain text matches the whole line as one phrase
+		{"bob: ", http.StatusOK, 2},
+		{"ALICE", http.StatusOK, 3},
+		{"12:30", http.StatusOK, 2},
+		{"pepelaugh kappa", http.StatusNotFound, 0},
+		// operators use the filter language, its words only match messages
+		{"nick:alice", http.StatusOK, 2},
+		{"bob -nick:ban", http.StatusOK, 1},
+		{"re:/(/", http.StatusBadRequest, 0},
+	}
+	for _, c := range cases {
+		w := serve(DayHandle, "/", map[string]string{
+			"channel": "Test chatlog",
+			"month":   "January 2017",
+			"date":    "2017-01-10",
+			"filter":  c.filter,
+		})
+		lines := strings.Count(w.Body.String(), "[2017-")
+		if w.Code != c.code || lines != c.lines {
+			t.Errorf("%q, got: %d with %d lines; want: %d with %d lines", c.filter, w.Code, lines, c.code, c.lines)
+		}
+	}
+}
+
+func TestServeNickLogs(t *testing.T) {
+	dir := setTestLogs(t, map[string]string{
+		"2017-01-10.txt": testDay,
+		"2017-01-11.txt": "[2017-01-11 09:00:00 UTC] Alice: alice again\n",
+	})
+	cases := []struct {
+		filter string
+		want   string
+	}{
+		{"", "08:00:00,12:30:59,09:00:00"},
+		// plain text only matches messages
+		{"bob", "12:30:59"},
+		{"alice", "09:00:00"},
+		{"has:link", "08:00:00"},
+		{"after:2017-01-10", "09:00:00"},
+		{"before:2017-01-11 -has:link", "12:30:59"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		serveNickLogs(w, dir, "alice", c.filter)
+		var got []string
+		for _, line := range strings.SplitAfter(w.Body.String(), "\n") {
+			if len(line) > 20 {
+				got = append(got, line[12:20])
+			}
+		}
+		if strings.Join(got, ",") != c.want {
+			t.Errorf("%q, got: %v; want: %s", c.filter, got, c.want)
+		}
+	}
+}
+
+func TestDayRange(t *testing.T) {
+	line := func(ts string) []byte {
+		return []byte("[2017-01-10 " + ts + " UTC] bob: hi\n")
+	}
+	cases := []struct {
+		from, to string
+		want     map[string]bool
+	}{
+		{"12:30", "12:30", map[string]bool{"12:29:59": false, "12:30:00": true, "12:30:59": true, "12:31:00": false}},
+		{"12:30:30", "12:31:00", map[string]bool{"12:30:29": false, "12:30:30": true, "12:31:00": true, "12:31:01": false}},
+		{"", "08:00", map[string]bool{"00:00:00": true, "08:00:59": true, "08:01:00": false}},
+		{"23:59", "", map[string]bool{"23:58:59": false, "23:59:59": true}},
+	}
+	for _, c := range cases {
+		start, end, err := dayRange("2017-01-10", c.from, c.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ts, want := range c.want {
+			if got := lineBetween(line(ts), start, end); got != want {
+				t.Errorf("%s between %q and %q, got: %t; want: %t", ts, c.from, c.to, got, want)
+			}
+		}
+	}
+	if _, _, err := dayRange("2017-01-10", "noon", ""); err != ErrInvalidTime {
+		t.Errorf("expected invalid time error, got: %v", err)
+	}
+}
+
+func TestDayHandleRange(t *testing.T) {
+	dir := setTestLogs(t, map[string]string{"2017-01-10.txt": testDay})
+	check := func() {
+		t.Helper()
+		w := serve(DayHandle, "/?from=12:30&to=12:30", map[string]string{
+			"channel": "Test chatlog",
+			"month":   "January 2017",
+			"date":    "2017-01-10",
+		})
+		if got := strings.Count(w.Body.String(), "[2017-01-10 12:30:"); w.Code != http.StatusOK || got != 2 || strings.Count(w.Body.String(), "\n") != 2 {
+			t.Errorf("expected the two 12:30 lines, got: %d %q", w.Code, w.Body.String())
+		}
+	}
+	check()
+	// compressed logs are read through their frame index
+	if _, err := common.CompressFile(filepath.Join(dir, "2017-01-10.txt")); err != nil {
+		t.Fatal(err)
+	}
+	check()
+}
+
+func TestSearchAPIHandle(t *testing.T) {
+	dir := setTestLogs(t, map[string]string{"2017-01-10.txt": testDay})
+	feb := filepath.Join(filepath.Dir(dir), "February 2017")
+	if err := os.MkdirAll(feb, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(feb, "2017-02-01.txt"), []byte("[2017-02-01 10:00:00 UTC] carol: bob is back\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{dir, feb} {
+		if err := common.BuildSearchIndex(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type result struct {
+		Results []struct {
+			Date string
+			Line int
+			Nick string
+			URL  string
+		}
+		Next string
+	}
+	search := func(query string, code int) result {
+		t.Helper()
+		w := serve(SearchAPIHandle, "/api/v1/search?"+query, nil)
+		if w.Code != code {
+			t.Fatalf("%s, got: %d %s; want: %d", query, w.Code, w.Body.String(), code)
+		}
+		var res result
+		if code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res
+	}
+
+	res := search("channel=test&q=bob", http.StatusOK)
+	if len(res.Results) != 3 || res.Results[0].Date != "2017-02-01" || res.Results[1].Line != 3 || res.Results[2].Line != 2 || res.Next != "" {
+		t.Errorf("expected matches newest first, got: %+v", res)
+	}
+	if url := res.Results[2].URL; url != "/Test chatlog/January 2017/2017-01-10.txt?from=12:30:59&to=12:30:59" {
+		t.Errorf("invalid url, got: %s", url)
+	}
+
+	res = search("channel=test&q=bob&limit=2", http.StatusOK)
+	if len(res.Results) != 2 || res.Next == "" {
+		t.Fatalf("expected a next page, got: %+v", res)
+	}
+	res = search(strings.TrimPrefix(res.Next, "/api/v1/search?"), http.StatusOK)
+	if len(res.Results) != 1 || res.Results[0].Line != 2 || res.Next != "" {
+		t.Errorf("invalid last page, got: %+v", res)
+	}
+
+	res = search("channel=test&q=bob&nick=alice&to=2017-01-31", http.StatusOK)
+	if len(res.Results) != 1 || res.Results[0].Nick != "Alice" {
+		t.Errorf("invalid nick results, got: %+v", res)
+	}
+
+	search("q=bob", http.StatusBadRequest)
+	search("channel=test&q=bob&page=0", http.StatusBadRequest)
+	search("channel=test&q=bob&from=jan", http.StatusBadRequest)
+	search("channel=nobody&q=bob", http.StatusNotFound)
+}
+
+func TestMonthLogHandle(t *testing.T) {
+	setTestLogs(t, map[string]string{
+		"2017-01-10.txt": testDay,
+		common.BansFileName: "[2017-01-10 12:31:00 UTC] Ban: carl banned\n" +
+			"[2017-01-11 09:00:00 UTC] Ban: dave timed out for 600s\n",
+	})
+	cases := []struct {
+		handler http.HandlerFunc
+		filter  string
+		code    int
+		lines   int
+	}{
+		// a user called Ban in the day logs isn't a ban
+		{BanHandle, "", http.StatusOK, 2},
+		{BanHandle, "dave", http.StatusOK, 1},
+		{BanHandle, "after:2017-01-10", http.StatusOK, 1},
+		{BanHandle, "re:/(/", http.StatusBadRequest, 0},
+		{RaidHandle, "", http.StatusNotFound, 0},
+	}
+	for _, c := range cases {
+		w := serve(c.handler, "/", map[string]string{
+			"channel": "Test chatlog",
+			"month":   "January 2017",
+			"filter":  c.filter,
+		})
+		lines := strings.Count(w.Body.String(), "[2017-")
+		if w.Code != c.code || lines != c.lines {
+			t.Errorf("%q, got: %d with %d lines; want: %d with %d lines", c.filter, w.Code, lines, c.code, c.lines)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+// dictionary training settings
+const (
+	DictSampleDays  = 14
+	DictSampleLines = 100
+	// DictSampleBytes zstd recommends samples of about 100 times the
+	// dictionary size
+	DictSampleBytes = 100 * common.DictSize
+)
+
+// ./tool traindict /path/to/logs/ ["Channel chatlog"] [days]
+func trainDict() error {
+	if len(os.Args) < 3 {
+		return errors.New("not enough args")
+	}
+	logsPath := os.Args[2]
+	days := DictSampleDays
+	if len(os.Args) > 4 {
+		n, err := strconv.Atoi(os.Args[4])
+		if err != nil {
+			return err
+		}
+		days = n
+	}
+
+	var channels []string
+	if len(os.Args) > 3 {
+		channels = []string{os.Args[3]}
+	} else {
+		paths, err := filepath.Glob(filepath.Join(logsPath, "* chatlog"))
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			channels = append(channels, filepath.Base(p))
+		}
+	}
+
+	for _, ch := range channels {
+		samples, size, err := dictSamples(filepath.Join(logsPath, ch), days)
+		if err != nil {
+			log.Printf("error reading samples of %s %s", ch, err)
+			continue
+		}
+		data, err := common.TrainDict(samples, common.DictSize)
+		if err != nil {
+			log.Printf("error training %s %s", ch, err)
+			continue
+		}
+		d, err := common.WriteDict(common.ChannelDictDir(logsPath, ch), data)
+		if err != nil {
+			return err
+		}
+		log.Printf("trained dictionary %d for %s from %d samples (%d bytes), %d bytes", d.ID, ch, len(samples), size, len(d.Data))
+	}
+	return nil
+}
+
+// dictSamples splits the most recent compressed day logs of a channel into
+// samples of DictSampleLines lines
+func dictSamples(dir string, days int) ([][]byte, int, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "*.txt.gz"))
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return filepath.Base(paths[i]) > filepath.Base(paths[j])
+	})
+	if len(paths) > days {
+		paths = paths[:days]
+	}
+
+	var samples [][]byte
+	var size int
+	for _, path := range paths {
+		data, err := common.ReadCompressedFile(path)
+		if err != nil {
+			log.Printf("error reading %s %s", path, err)
+			continue
+		}
+		r := bufio.NewScanner(bytes.NewReader(data))
+		r.Buffer(nil, len(data)+1)
+		var sample strings.Builder
+		var lines int
+		for r.Scan() {
+			sample.Write(r.Bytes())
+			sample.WriteByte('\n')
+			if lines++; lines == DictSampleLines {
+				samples = append(samples, []byte(sample.String()))
+				size += sample.Len()
+				sample.Reset()
+				lines = 0
+			}
+			if size >= DictSampleBytes {
+				return samples, size, nil
+			}
+		}
+		if sample.Len() > 0 {
+			samples = append(samples, []byte(sample.String()))
+			size += sample.Len()
+		}
+	}
+	if len(samples) == 0 {
+		return nil, 0, errors.New("no compressed logs")
+	}
+	return samples, size, nil
+}
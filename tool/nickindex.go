@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+// ./tool nickindex /path/to/logs/ ["Channel chatlog"] ["January 2006"]
+func nickIndex() error {
+	dirs, err := monthDirs()
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		index, err := common.BuildNickIndex(dir)
+		if err != nil {
+			log.Printf("error indexing %s %s", dir, err)
+			continue
+		}
+		if err := index.WriteTo(common.NickIndexPath(dir)); err != nil {
+			log.Printf("error writing nick index of %s %s", dir, err)
+			continue
+		}
+		log.Printf("indexed %d nicks in %s", len(index), dir)
+	}
+	return nil
+}
+
+// monthDirs month directories picked by the logs path, channel and month
+// args, every channel or month if they're left out
+func monthDirs() ([]string, error) {
+	if len(os.Args) < 3 {
+		return nil, errors.New("not enough args")
+	}
+	logsPath := os.Args[2]
+	store := common.Store()
+
+	var channels []string
+	if len(os.Args) > 3 {
+		channels = []string{os.Args[3]}
+	} else {
+		var err error
+		if channels, err = common.Channels(store, logsPath); err != nil {
+			return nil, err
+		}
+	}
+
+	var dirs []string
+	for _, ch := range channels {
+		var months []string
+		if len(os.Args) > 4 {
+			months = []string{os.Args[4]}
+		} else {
+			var err error
+			if months, err = common.Months(store, logsPath, ch); err != nil {
+				log.Printf("error listing months of %s %s", ch, err)
+				continue
+			}
+		}
+		for _, m := range months {
+			dirs = append(dirs, filepath.Join(logsPath, ch, m))
+		}
+	}
+	return dirs, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+// ./tool searchindex /path/to/logs/ ["Channel chatlog"] ["January 2006"]
+// months being logged are appended to by the logger, rebuild them with the
+// logger stopped
+func searchIndex() error {
+	dirs, err := monthDirs()
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if err := common.BuildSearchIndex(dir); err != nil {
+			log.Printf("error indexing %s %s", dir, err)
+			continue
+		}
+		log.Printf("indexed %s", dir)
+	}
+	return nil
+}
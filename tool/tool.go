@@ -23,7 +23,6 @@ import (
 	"github.com/MemeLabs/overrustlelogs/common"
 	"github.com/MemeLabs/overrustlelogs/tool/avro"
 	"github.com/actgardner/gogen-avro/container"
-	lz4 "github.com/cloudflare/golz4"
 	"github.com/pkg/profile"
 	pb "gopkg.in/cheggaaa/pb.v1"
 )
@@ -42,6 +41,11 @@ var commands = map[string]command{
 	"convert":          convertToZSTD,
 	"createtoplist":    createTopList,
 	"uploadToBigQuery": uploadToBigQuery,
+	"traindict":        trainDict,
+	"archive":          archive,
+	"nickindex":        nickIndex,
+	"userdir":          userDirectory,
+	"searchindex":      searchIndex,
 }
 
 func main() {
@@ -51,6 +55,16 @@ func main() {
 	}
 	p := profile.Start(profile.MemProfile)
 
+	// archived logs are read from the s3 bucket in the logger config
+	if path := os.Getenv("ORL_CONFIG"); path != "" {
+		root := os.Getenv("ORL_LOGS")
+		if root == "" {
+			root = "/logs"
+		}
+		common.SetupConfig(path)
+		common.SetStore(common.NewStoreFromConfig(root))
+	}
+
 	if c, ok := commands[os.Args[1]]; ok {
 		if err := c(); err != nil {
 			fmt.Println(err)
@@ -75,6 +89,19 @@ func compress() error {
 	return err
 }
 
+// ORL_CONFIG=/path/to/overrustlelogs.toml ./tool archive /path/to/logs/
+func archive() error {
+	if len(os.Args) < 3 {
+		return errors.New("not enough args")
+	}
+	conf := common.GetConfig()
+	if conf == nil || conf.S3.Bucket == "" {
+		return errors.New("ORL_CONFIG doesn't have s3 settings")
+	}
+	root := filepath.Clean(os.Args[2])
+	return common.ArchiveMonths(common.FileStore{}, common.NewS3Store(conf.S3, root), root, time.Now().UTC())
+}
+
 func uncompress() error {
 	if len(os.Args) < 3 {
 		return errors.New("not enough args")
@@ -241,6 +268,14 @@ func cleanup() error {
 		if r.MatchString(fp) || strings.Contains(fp, now.Format("2006-01-02")) {
 			continue
 		}
+		// gap records are appended to for the whole month
+		if filepath.Base(fp) == common.GapsFileName {
+			continue
+		}
+		// frame indexes and dictionaries aren't logs
+		if strings.HasSuffix(fp, ".idx") || strings.HasSuffix(fp, ".dict") {
+			continue
+		}
 		_, err := common.CompressFile(fp)
 		if err != nil {
 			log.Panicf("error writing compressed file: %v", err)
@@ -282,32 +317,13 @@ func convertToZSTD() error {
 	return nil
 }
 
-// ReadCompressedFile read compressed file
-func ReadCompressedFile(path string) ([]byte, error) {
-	f, err := os.Open(lz4Path(path))
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	c, err := ioutil.ReadAll(f)
+// UncompressFile uncompress an existing legacy lz4 file
+func UncompressFile(path string) (*os.File, error) {
+	c, err := ioutil.ReadFile(lz4Path(path))
 	if err != nil {
 		return nil, err
 	}
-	size := uint32(0)
-	size |= uint32(c[0]) << 24
-	size |= uint32(c[1]) << 16
-	size |= uint32(c[2]) << 8
-	size |= uint32(c[3])
-	data := make([]byte, size)
-	if err := lz4.Uncompress(c[4:], data); err != nil {
-		return nil, err
-	}
-	return data, nil
-}
-
-// UncompressFile uncompress an existing file
-func UncompressFile(path string) (*os.File, error) {
-	d, err := ReadCompressedFile(path)
+	d, err := common.Decompress(c)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"github.com/MemeLabs/overrustlelogs/common"
+)
+
+// ./tool userdir /path/to/logs/
+func userDirectory() error {
+	if len(os.Args) < 3 {
+		return errors.New("not enough args")
+	}
+	logsPath := os.Args[2]
+	d, err := common.BuildUserDirectory(logsPath)
+	if err != nil {
+		return err
+	}
+	if err := d.WriteTo(logsPath); err != nil {
+		return err
+	}
+	log.Printf("indexed %d nicks", len(d))
+	return nil
+}